@@ -0,0 +1,265 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+// AwtrixDefinition holds the Awtrix 3 custom app options added to every
+// published message. The keys are the json keys of the Awtrix api, unset
+// options are not sent. It can be set on an entry and on a publish topic, the
+// options of the topic win.
+//
+// Every option except bar and line can also be computed from the value with a
+// script in scripts, keyed by the option name. The script must define
+// get_<option>(v), e.g. get_progress(v).
+type AwtrixDefinition struct {
+	Duration     *int    `yaml:"duration,omitempty" json:"duration,omitempty"`
+	Lifetime     *int    `yaml:"lifetime,omitempty" json:"lifetime,omitempty"`
+	LifetimeMode *int    `yaml:"lifetimeMode,omitempty" json:"lifetimeMode,omitempty"`
+	Progress     *int    `yaml:"progress,omitempty" json:"progress,omitempty"`
+	ProgressC    string  `yaml:"progressC,omitempty" json:"progressC,omitempty"`
+	ProgressBC   string  `yaml:"progressBC,omitempty" json:"progressBC,omitempty"`
+	Bar          []int   `yaml:"bar,omitempty" json:"bar,omitempty"`
+	Line         []int   `yaml:"line,omitempty" json:"line,omitempty"`
+	Autoscale    *bool   `yaml:"autoscale,omitempty" json:"autoscale,omitempty"`
+	BarBC        string  `yaml:"barBC,omitempty" json:"barBC,omitempty"`
+	Rainbow      *bool   `yaml:"rainbow,omitempty" json:"rainbow,omitempty"`
+	PushIcon     *int    `yaml:"pushIcon,omitempty" json:"pushIcon,omitempty"`
+	TextCase     *int    `yaml:"textCase,omitempty" json:"textCase,omitempty"`
+	TopText      *bool   `yaml:"topText,omitempty" json:"topText,omitempty"`
+	TextOffset   *int    `yaml:"textOffset,omitempty" json:"textOffset,omitempty"`
+	Center       *bool   `yaml:"center,omitempty" json:"center,omitempty"`
+	Background   string  `yaml:"background,omitempty" json:"background,omitempty"`
+	BlinkText    *int    `yaml:"blinkText,omitempty" json:"blinkText,omitempty"`
+	FadeText     *int    `yaml:"fadeText,omitempty" json:"fadeText,omitempty"`
+	Effect       *string `yaml:"effect,omitempty" json:"effect,omitempty"`
+	Repeat       *int    `yaml:"repeat,omitempty" json:"repeat,omitempty"`
+	NoScroll     *bool   `yaml:"noScroll,omitempty" json:"noScroll,omitempty"`
+	ScrollSpeed  *int    `yaml:"scrollSpeed,omitempty" json:"scrollSpeed,omitempty"`
+	Pos          *int    `yaml:"pos,omitempty" json:"pos,omitempty"`
+	Save         *bool   `yaml:"save,omitempty" json:"save,omitempty"`
+	Overlay      *string `yaml:"overlay,omitempty" json:"overlay,omitempty"`
+
+	Scripts map[string]string `yaml:"scripts,omitempty" json:"-"`
+
+	// Late binding
+	ScriptCallbacks map[string]func(interface{}) (interface{}, error) `yaml:"-" json:"-"`
+}
+
+const (
+	// awtrixMaxChartValues is the number of bar or line values Awtrix can show without icon.
+	awtrixMaxChartValues = 16
+)
+
+// awtrixIntRanges are the allowed ranges of the integer options.
+var awtrixIntRanges = map[string][2]int{
+	"duration":     {0, math.MaxInt32},
+	"lifetime":     {0, math.MaxInt32},
+	"lifetimeMode": {0, 1},
+	"progress":     {0, 100},
+	"pushIcon":     {0, 2},
+	"textCase":     {0, 2},
+	"textOffset":   {math.MinInt32, math.MaxInt32},
+	"blinkText":    {0, math.MaxInt32},
+	"fadeText":     {0, math.MaxInt32},
+	"repeat":       {-1, math.MaxInt32},
+	"scrollSpeed":  {1, math.MaxInt32},
+	"pos":          {0, math.MaxInt32},
+}
+
+// awtrixColorOptions are the options that take a hex color.
+var awtrixColorOptions = map[string]bool{
+	"progressC":  true,
+	"progressBC": true,
+	"barBC":      true,
+	"background": true,
+}
+
+// awtrixField returns the struct field of the option with the json key name.
+func awtrixField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag == name && tag != "-" {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// checkAwtrixValue validates a single option value: ranges of integers and hex colors.
+func checkAwtrixValue(name string, value interface{}) error {
+	switch v := value.(type) {
+	case int:
+		if r, ok := awtrixIntRanges[name]; ok && (v < r[0] || v > r[1]) {
+			return fmt.Errorf("ERROR: AWTRIX %s OUT OF RANGE %d..%d: %d", name, r[0], r[1], v)
+		}
+	case string:
+		if awtrixColorOptions[name] && !isValidHexColor(v) {
+			return fmt.Errorf("ERROR: INVALID AWTRIX COLOR %s: '%s'", name, v)
+		}
+	}
+	return nil
+}
+
+// validateAwtrix checks all static options and compiles the scripts, which are
+// smoke-tested with smoke (1.0 for numeric entries, "" for text entries).
+func validateAwtrix(a *AwtrixDefinition, smoke interface{}) error {
+	v := reflect.ValueOf(a).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		f := v.Field(i)
+		switch {
+		case name == "-" || f.IsZero():
+			continue
+		case f.Kind() == reflect.Ptr && f.Elem().Kind() == reflect.Int:
+			if err := checkAwtrixValue(name, int(f.Elem().Int())); err != nil {
+				return err
+			}
+		case f.Kind() == reflect.String:
+			if err := checkAwtrixValue(name, f.String()); err != nil {
+				return err
+			}
+		case f.Kind() == reflect.Slice && f.Len() > awtrixMaxChartValues:
+			return fmt.Errorf("ERROR: AWTRIX %s HAS MORE THAN %d VALUES", name, awtrixMaxChartValues)
+		}
+	}
+
+	a.ScriptCallbacks = make(map[string]func(interface{}) (interface{}, error), len(a.Scripts))
+	for name, script := range a.Scripts {
+		field, ok := awtrixField(v, name)
+		if !ok || field.Kind() == reflect.Slice {
+			return fmt.Errorf("ERROR: UNKNOWN AWTRIX SCRIPT OPTION '%s'", name)
+		}
+		cb, err := createAwtrixCallback(name, field.Type(), script, smoke)
+		if err != nil {
+			return fmt.Errorf("ERROR CREATING AWTRIX SCRIPT '%s': %v", name, err)
+		}
+		a.ScriptCallbacks[name] = cb
+	}
+	return nil
+}
+
+// createAwtrixCallback compiles a script defining get_<name>(v) and converts
+// its result to the kind of the option.
+func createAwtrixCallback(name string, fieldType reflect.Type, script string, smoke interface{}) (func(interface{}) (interface{}, error), error) {
+	vm, err := loadScript(script)
+	if err != nil {
+		return nil, err
+	}
+
+	fn, ok := goja.AssertFunction(vm.Get("get_" + name))
+	if !ok {
+		return nil, fmt.Errorf("ERROR GETTING GET_%s FUNCTION FROM SCRIPT", strings.ToUpper(name))
+	}
+
+	call := func(value interface{}) (interface{}, error) {
+		res, err := fn(goja.Undefined(), vm.ToValue(value))
+		if err != nil {
+			return nil, err
+		}
+		var out interface{}
+		switch {
+		case fieldType.Kind() == reflect.String:
+			out = res.String()
+		case fieldType.Elem().Kind() == reflect.Int:
+			f := res.ToFloat()
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, fmt.Errorf("GET_%s DID NOT RETURN A NUMBER: '%v'", strings.ToUpper(name), res)
+			}
+			out = int(math.Round(f))
+		case fieldType.Elem().Kind() == reflect.Bool:
+			out = res.ToBoolean()
+		case fieldType.Elem().Kind() == reflect.String:
+			out = res.String()
+		default:
+			return nil, errors.New("UNSUPPORTED OPTION TYPE")
+		}
+		if err := checkAwtrixValue(name, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+
+	if _, err := call(smoke); err != nil {
+		return nil, err
+	}
+
+	// A goja runtime is not safe for concurrent use.
+	var mu sync.Mutex
+	return func(value interface{}) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return call(value)
+	}, nil
+}
+
+// mergeAwtrix returns base with every option set in override replaced. Either may be nil.
+func mergeAwtrix(base, override *AwtrixDefinition) *AwtrixDefinition {
+	if base == nil {
+		return override
+	}
+	if override == nil {
+		return base
+	}
+
+	merged := *base
+	mv := reflect.ValueOf(&merged).Elem()
+	ov := reflect.ValueOf(override).Elem()
+	for i := 0; i < mv.NumField(); i++ {
+		if mv.Field(i).Kind() == reflect.Map {
+			continue
+		}
+		if !ov.Field(i).IsZero() {
+			mv.Field(i).Set(ov.Field(i))
+		}
+	}
+
+	merged.Scripts = make(map[string]string)
+	merged.ScriptCallbacks = make(map[string]func(interface{}) (interface{}, error))
+	for _, a := range []*AwtrixDefinition{base, override} {
+		for name, script := range a.Scripts {
+			merged.Scripts[name] = script
+			merged.ScriptCallbacks[name] = a.ScriptCallbacks[name]
+		}
+	}
+	return &merged
+}
+
+// Resolve returns the options with the scripted options computed from value.
+// An option whose script fails keeps its static setting; the first error is returned.
+func (a *AwtrixDefinition) Resolve(value interface{}) (*AwtrixDefinition, error) {
+	if a == nil || len(a.ScriptCallbacks) == 0 {
+		return a, nil
+	}
+
+	resolved := *a
+	rv := reflect.ValueOf(&resolved).Elem()
+	var firstErr error
+	for name, cb := range a.ScriptCallbacks {
+		out, err := cb(value)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("awtrix script '%s': %v", name, err)
+			}
+			continue
+		}
+		field, _ := awtrixField(rv, name)
+		if field.Kind() == reflect.Ptr {
+			p := reflect.New(field.Type().Elem())
+			p.Elem().Set(reflect.ValueOf(out))
+			field.Set(p)
+		} else {
+			field.Set(reflect.ValueOf(out))
+		}
+	}
+	return &resolved, firstErr
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func intPtr(i int) *int { return &i }
+
+func TestValidateAwtrix(t *testing.T) {
+	tests := []struct {
+		name    string
+		awtrix  AwtrixDefinition
+		wantErr string
+	}{
+		{
+			name:   "Valid",
+			awtrix: AwtrixDefinition{Duration: intPtr(10), Progress: intPtr(0), ProgressC: "#00FF00", Repeat: intPtr(-1)},
+		},
+		{
+			name:    "ProgressOutOfRange",
+			awtrix:  AwtrixDefinition{Progress: intPtr(101)},
+			wantErr: "ERROR: AWTRIX progress OUT OF RANGE 0..100: 101",
+		},
+		{
+			name:    "TextCaseOutOfRange",
+			awtrix:  AwtrixDefinition{TextCase: intPtr(3)},
+			wantErr: "ERROR: AWTRIX textCase OUT OF RANGE 0..2: 3",
+		},
+		{
+			name:    "InvalidColor",
+			awtrix:  AwtrixDefinition{Background: "red"},
+			wantErr: "ERROR: INVALID AWTRIX COLOR background: 'red'",
+		},
+		{
+			name:    "TooManyBarValues",
+			awtrix:  AwtrixDefinition{Bar: make([]int, 17)},
+			wantErr: "ERROR: AWTRIX bar HAS MORE THAN 16 VALUES",
+		},
+		{
+			name:    "UnknownScriptOption",
+			awtrix:  AwtrixDefinition{Scripts: map[string]string{"bogus": "function get_bogus(v) { return 1; }"}},
+			wantErr: "ERROR: UNKNOWN AWTRIX SCRIPT OPTION 'bogus'",
+		},
+		{
+			name:    "ScriptForChart",
+			awtrix:  AwtrixDefinition{Scripts: map[string]string{"bar": "function get_bar(v) { return [1]; }"}},
+			wantErr: "ERROR: UNKNOWN AWTRIX SCRIPT OPTION 'bar'",
+		},
+		{
+			name:    "ScriptMissingFunction",
+			awtrix:  AwtrixDefinition{Scripts: map[string]string{"progress": "function get_value(v) { return 1; }"}},
+			wantErr: "ERROR GETTING GET_PROGRESS FUNCTION FROM SCRIPT",
+		},
+		{
+			name:    "ScriptInvalidColor",
+			awtrix:  AwtrixDefinition{Scripts: map[string]string{"progressC": "function get_progressC(v) { return 'green'; }"}},
+			wantErr: "ERROR: INVALID AWTRIX COLOR progressC: 'green'",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateAwtrix(&tc.awtrix, 1.0)
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestAwtrixMergeAndResolve(t *testing.T) {
+	entry := &AwtrixDefinition{
+		Duration: intPtr(10),
+		Lifetime: intPtr(120),
+		Scripts:  map[string]string{"progress": "function get_progress(v) { return v / 10; }"},
+	}
+	topic := &AwtrixDefinition{
+		Duration: intPtr(5),
+		Scripts:  map[string]string{"rainbow": "function get_rainbow(v) { return v > 900; }"},
+	}
+	require.NoError(t, validateAwtrix(entry, 1.0))
+	require.NoError(t, validateAwtrix(topic, 1.0))
+
+	merged := mergeAwtrix(entry, topic)
+	assert.Equal(t, 5, *merged.Duration, "topic wins")
+	assert.Equal(t, 120, *merged.Lifetime, "entry option is kept")
+	assert.Len(t, merged.ScriptCallbacks, 2)
+	assert.Equal(t, 10, *entry.Duration, "merge must not modify the entry options")
+
+	resolved, err := merged.Resolve(950.0)
+	assert.NoError(t, err)
+	assert.Equal(t, 95, *resolved.Progress)
+	assert.True(t, *resolved.Rainbow)
+	assert.Nil(t, merged.Progress, "resolve must not modify the merged options")
+
+	// Out of range results are skipped and reported.
+	resolved, err = merged.Resolve(2000.0)
+	assert.ErrorContains(t, err, "awtrix script 'progress'")
+	assert.Nil(t, resolved.Progress)
+	assert.True(t, *resolved.Rainbow)
+
+	assert.Nil(t, mergeAwtrix(nil, nil))
+	assert.Same(t, entry, mergeAwtrix(entry, nil))
+}
+
+func TestLoadConfigAwtrix(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - awtrix:
+      duration: 10
+      progressC: "#00FF00"
+    topics-to-publish:
+      - topic: "a"
+        awtrix:
+          duration: 5
+          scripts:
+            progress: |
+              function get_progress(v) { return Math.min(100, v); }
+      - topic: "b"
+`), nil
+	}
+
+	cfg, err := LoadConfig("dummy_path")
+	require.NoError(t, err)
+	topics := cfg.DispatcherEntries[0].TopicsToPublish
+	assert.Equal(t, 5, *topics[0].ResolvedAwtrix.Duration)
+	assert.Equal(t, "#00FF00", topics[0].ResolvedAwtrix.ProgressC)
+	assert.Contains(t, topics[0].ResolvedAwtrix.ScriptCallbacks, "progress")
+	assert.Equal(t, 10, *topics[1].ResolvedAwtrix.Duration)
+
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - topics-to-publish:
+      - topic: "a"
+        awtrix:
+          pushIcon: 5
+`), nil
+	}
+	cfg, err = LoadConfig("dummy_path")
+	assert.Nil(t, cfg)
+	assert.ErrorContains(t, err, "ERROR: AWTRIX pushIcon OUT OF RANGE 0..2: 5 INDEX 0 TOPIC 0")
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Broker returns the broker named name, the mqtt section for "".
+func (c *RootConfig) Broker(name string) (MqttConfig, bool) {
+	if name == "" {
+		return c.Mqtt, true
+	}
+	for _, b := range c.Brokers {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return MqttConfig{}, false
+}
+
+// validateBrokers parses and checks the brokers like the mqtt section, the
+// heartbeat is only published on the default broker.
+func validateBrokers(cfg *RootConfig, v *validator, mqttFile string) {
+	if cfg.Mqtt.Name != "" {
+		v.add(mqttFile, false, errors.New("ERROR: THE MQTT SECTION IS THE DEFAULT BROKER AND HAS NO NAME"), "mqtt", "name")
+	}
+
+	for b_i := range cfg.Brokers {
+		b := &cfg.Brokers[b_i]
+		if b.Name == "" {
+			v.brokerError(b_i, fmt.Errorf("ERROR: BROKER NAME IS REQUIRED: '%s'", b.Broker))
+		}
+
+		var err error
+		if b.BrokerAsUri, err = url.Parse(b.Broker); err != nil {
+			v.brokerError(b_i, err, "broker")
+		} else if err := validateBroker(b); err != nil {
+			v.brokerError(b_i, err)
+		}
+		if b.HeartbeatInterval != "" {
+			v.brokerError(b_i, errors.New("ERROR: HEARTBEAT-INTERVAL IS ONLY SUPPORTED IN THE MQTT SECTION"), "heartbeat-interval")
+		} else if err := validateStatus(b); err != nil {
+			v.brokerError(b_i, err, "status-topic")
+		}
+		if err := validateQos(b.Qos); err != nil {
+			v.brokerError(b_i, err, "qos")
+		}
+		if err := validateProtocolVersion(*b); err != nil {
+			v.brokerError(b_i, err, "protocol-version")
+		}
+	}
+}
+
+// resolveBrokers checks the source-broker and publish-broker of the entries and
+// sets the broker of their topics.
+func resolveBrokers(cfg *RootConfig, v *validator) {
+	for e_i := range cfg.DispatcherEntries {
+		e := &cfg.DispatcherEntries[e_i]
+		if _, ok := cfg.Broker(e.SourceBroker); !ok {
+			v.entryError(e_i, fmt.Errorf("ERROR: UNKNOWN SOURCE-BROKER '%s' INDEX %d", e.SourceBroker, e_i), "source-broker")
+		}
+		if _, ok := cfg.Broker(e.PublishBroker); !ok {
+			v.entryError(e_i, fmt.Errorf("ERROR: UNKNOWN PUBLISH-BROKER '%s' INDEX %d", e.PublishBroker, e_i), "publish-broker")
+		}
+
+		if e.Source.MqttSource != nil {
+			for t_i := range e.Source.MqttSource.TopicsToSubscribe {
+				e.Source.MqttSource.TopicsToSubscribe[t_i].ResolvedBroker = e.SourceBroker
+			}
+		} else if e.SourceBroker != "" {
+			v.entryError(e_i, fmt.Errorf("ERROR: SOURCE-BROKER REQUIRES AN MQTT SOURCE INDEX %d", e_i), "source-broker")
+		}
+		for t_i := range e.TopicsToPublish {
+			e.TopicsToPublish[t_i].ResolvedBroker = e.PublishBroker
+		}
+	}
+}
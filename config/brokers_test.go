@@ -0,0 +1,110 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigBrokers(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+brokers:
+  - name: "shellies"
+    broker: "tcp://shellies:1883"
+    qos: 1
+  - name: "awtrix"
+    broker: "mqtts://awtrix:8883"
+    retain: false
+dispatcher-entries:
+  - name: "power"
+    source-broker: "shellies"
+    publish-broker: "awtrix"
+    source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "power"
+    topics-to-publish:
+      - topic: "awtrix/custom/power"
+  - name: "solar"
+    source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "solar"
+    topics-to-publish:
+      - topic: "awtrix/custom/solar"
+`), nil
+	}
+
+	cfg, err := LoadConfig("config.yaml")
+	require.NoError(t, err)
+	require.Len(t, cfg.Brokers, 2)
+	assert.NotNil(t, cfg.Brokers[1].TLSConfig)
+
+	b, ok := cfg.Broker("shellies")
+	assert.True(t, ok)
+	assert.Equal(t, "tcp://shellies:1883", b.Broker)
+	b, ok = cfg.Broker("")
+	assert.True(t, ok)
+	assert.Equal(t, "tcp://localhost:1883", b.Broker)
+
+	power := cfg.DispatcherEntries[0]
+	assert.Equal(t, "shellies", power.Source.MqttSource.TopicsToSubscribe[0].ResolvedBroker)
+	assert.Equal(t, byte(1), power.Source.MqttSource.TopicsToSubscribe[0].ResolvedQos)
+	assert.Equal(t, "awtrix", power.TopicsToPublish[0].ResolvedBroker)
+	assert.False(t, power.TopicsToPublish[0].ResolvedRetain)
+
+	solar := cfg.DispatcherEntries[1]
+	assert.Equal(t, "", solar.Source.MqttSource.TopicsToSubscribe[0].ResolvedBroker)
+	assert.Equal(t, "", solar.TopicsToPublish[0].ResolvedBroker)
+	assert.True(t, solar.TopicsToPublish[0].ResolvedRetain)
+}
+
+func TestLoadConfigBrokerErrors(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+  name: "default"
+brokers:
+  - broker: "tcp://shellies:1883"
+  - name: "awtrix"
+    broker: "ftp://awtrix"
+    heartbeat-interval: "1m"
+  - name: "awtrix"
+    broker: "tcp://awtrix:1883"
+dispatcher-entries:
+  - name: "power"
+    source-broker: "shelly"
+    publish-broker: "clock"
+    source:
+      http:
+        interval_sec: 10
+        urls:
+          - url: "http://shelly/status"
+    topics-to-publish:
+      - topic: "awtrix/custom/power"
+`), nil
+	}
+
+	cfg, err := LoadConfig("config.yaml")
+	assert.Nil(t, cfg)
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+
+	var problems []string
+	for _, p := range verr.Problems {
+		problems = append(problems, p.String())
+	}
+	assert.Equal(t, []string{
+		"config.yaml:3: ERROR: THE MQTT SECTION IS THE DEFAULT BROKER AND HAS NO NAME",
+		"config.yaml:5: ERROR: BROKER NAME IS REQUIRED: 'tcp://shellies:1883'",
+		"config.yaml:6: ERROR: UNSUPPORTED BROKER SCHEME: 'ftp'",
+		"config.yaml:8: ERROR: HEARTBEAT-INTERVAL IS ONLY SUPPORTED IN THE MQTT SECTION",
+		"config.yaml:9: ERROR: DUPLICATE BROKER 'awtrix', FIRST DEFINED IN 'config.yaml'",
+		"config.yaml:13: ERROR: UNKNOWN SOURCE-BROKER 'shelly' INDEX 0",
+		"config.yaml:13: ERROR: SOURCE-BROKER REQUIRES AN MQTT SOURCE INDEX 0",
+		"config.yaml:14: ERROR: UNKNOWN PUBLISH-BROKER 'clock' INDEX 0",
+	}, problems)
+}
@@ -5,8 +5,6 @@ import (
 	"net/url"
 	"os"
 	"time"
-
-	"gopkg.in/yaml.v2"
 )
 
 var (
@@ -15,35 +13,158 @@ var (
 	}
 )
 
+// LoadConfig loads a config file, or all *.yaml and *.yml files of a directory,
+// together with the included files. All problems of the config are returned as
+// *ValidationError, warnings are available with Warnings.
 func LoadConfig(path string) (*RootConfig, error) {
-	data, err := osReadFile(path)
+	l := newConfigLoader()
+	loaded, err := l.load(path)
 	if err != nil {
 		return nil, err
 	}
-	var cfg RootConfig
-	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
-		return nil, err
+	cfg := *loaded
+	v := l.v
+	section := func(key string, err error, path ...interface{}) {
+		v.add(l.sections[key], false, err, append([]interface{}{key}, path...)...)
 	}
 
+	resolveReferences(&cfg, v, l.sections["mqtt"])
+
 	// Parse mqtt broker as url
 	cfg.Mqtt.BrokerAsUri, err = url.Parse(cfg.Mqtt.Broker)
 	if err != nil {
-		return nil, err
+		section("mqtt", err, "broker")
+	} else if err := validateBroker(&cfg.Mqtt); err != nil {
+		section("mqtt", err)
+	}
+
+	if err := validateStatus(&cfg.Mqtt); err != nil {
+		section("mqtt", err)
+	}
+	if err := validateProtocolVersion(cfg.Mqtt); err != nil {
+		section("mqtt", err, "protocol-version")
+	}
+
+	validateBrokers(&cfg, v, l.sections["mqtt"])
+	resolveBrokers(&cfg, v)
+	validateQosAndRetain(&cfg, v, l.sections["mqtt"])
+	validateMqtt5(&cfg, v)
+
+	if cfg.Logging != nil {
+		if err := validateLogging(cfg.Logging); err != nil {
+			section("logging", err)
+		}
+	}
+
+	if cfg.State != nil {
+		if err := validateState(cfg.State); err != nil {
+			section("state", err)
+		}
+	}
+
+	if cfg.HttpServer != nil {
+		if err := validateHttpServer(cfg.HttpServer); err != nil {
+			section("http-server", err)
+		}
+	}
+
+	for e_i, e := range cfg.DispatcherEntries {
+		v.validateEntry(e_i, e)
+		v.validateTopics(e_i, e)
+		if !isValidOperator(operator(e.Operation)) {
+			v.entryError(e_i, fmt.Errorf("ERROR: INVALID OPERATION INDEX %d: '%s'", e_i, e.Operation), "operation")
+		}
+	}
+
+	for e_i, e := range cfg.DispatcherEntries {
+		if err := validateValueTypes(e); err != nil {
+			v.entryError(e_i, fmt.Errorf("%v INDEX %d", err, e_i), "source")
+		}
+		if err := validateFragments(e); err != nil {
+			v.entryError(e_i, fmt.Errorf("%v INDEX %d", err, e_i), "topics-to-publish")
+		}
+		if err := validateHistoryCharts(&cfg.DispatcherEntries[e_i]); err != nil {
+			v.entryError(e_i, fmt.Errorf("%v INDEX %d", err, e_i), "topics-to-publish")
+		}
+		if err := validateSourceExpiry(&cfg.DispatcherEntries[e_i]); err != nil {
+			v.entryError(e_i, fmt.Errorf("%v INDEX %d", err, e_i), "source-max-age")
+		}
 	}
 
 	for e_i, e := range cfg.DispatcherEntries {
-		if e.Operation != string(OperatorNone) && e.Operation != string(OperatorSum) {
-			return nil, fmt.Errorf("ERROR: INVALID OPERATION INDEX %d: '%s'", e_i, e.Operation)
+		if !isScriptName(e.ColorScript) {
+			continue
+		}
+		script, ok := cfg.ColorScripts[e.ColorScript]
+		if !ok {
+			v.entryError(e_i, fmt.Errorf("ERROR: UNKNOWN COLOR-SCRIPT '%s' INDEX %d", e.ColorScript, e_i), "color-script")
+			cfg.DispatcherEntries[e_i].ColorScript = ""
+			continue
 		}
+		cfg.DispatcherEntries[e_i].ColorScript = script
 	}
 
 	for e_i, e := range cfg.DispatcherEntries {
-		if e.ColorScript != "" {
-			colorCallback, err := createColorCallback(e.ColorScript)
+		if e.ColorScript == "" {
+			continue
+		}
+		if e.HasTextValue() {
+			textColorCallback, err := createTextColorCallback(e.ColorScript)
 			if err != nil {
-				return nil, fmt.Errorf("ERROR CREATING COLOR CALLBACK FOR CONFIG %d: %v", e_i, err)
+				v.entryError(e_i, fmt.Errorf("ERROR CREATING COLOR CALLBACK FOR CONFIG %d: %v", e_i, err), "color-script")
+				continue
 			}
-			cfg.DispatcherEntries[e_i].ColorScriptCallback = colorCallback
+			cfg.DispatcherEntries[e_i].TextColorCallback = textColorCallback
+			continue
+		}
+		colorCallback, err := createColorCallback(e.ColorScript)
+		if err != nil {
+			v.entryError(e_i, fmt.Errorf("ERROR CREATING COLOR CALLBACK FOR CONFIG %d: %v", e_i, err), "color-script")
+			continue
+		}
+		cfg.DispatcherEntries[e_i].ColorScriptCallback = colorCallback
+	}
+
+	for e_i, e := range cfg.DispatcherEntries {
+		if e.ValueScript == "" {
+			continue
+		}
+		if e.Operation != string(OperatorNone) {
+			v.entryError(e_i, fmt.Errorf("ERROR: OPERATION AND VALUE-SCRIPT ARE EXCLUSIVE INDEX %d", e_i), "value-script")
+			continue
+		}
+		valueCallback, err := createValueCallback(e.ValueScript, e.SourceIDs())
+		if err != nil {
+			v.entryError(e_i, fmt.Errorf("ERROR CREATING VALUE CALLBACK FOR CONFIG %d: %v", e_i, err), "value-script")
+			continue
+		}
+		cfg.DispatcherEntries[e_i].ValueScriptCallback = valueCallback
+	}
+
+	for e_i, e := range cfg.DispatcherEntries {
+		var smoke interface{} = 1.0
+		if e.HasTextValue() {
+			smoke = ""
+		}
+		if e.Source.MqttSource != nil {
+			for t_i, t := range e.Source.MqttSource.TopicsToSubscribe {
+				if t.Awtrix != nil {
+					v.entryError(e_i, fmt.Errorf("ERROR: AWTRIX IS ONLY ALLOWED ON ENTRIES AND PUBLISH TOPICS INDEX %d", e_i), "source", "mqtt", "topics-to-subscribe", t_i, "awtrix")
+				}
+			}
+		}
+		if e.Awtrix != nil {
+			if err := validateAwtrix(e.Awtrix, smoke); err != nil {
+				v.entryError(e_i, fmt.Errorf("%v INDEX %d", err, e_i), "awtrix")
+			}
+		}
+		for t_i, t := range e.TopicsToPublish {
+			if t.Awtrix != nil {
+				if err := validateAwtrix(t.Awtrix, smoke); err != nil {
+					v.entryError(e_i, fmt.Errorf("%v INDEX %d TOPIC %d", err, e_i, t_i), "topics-to-publish", t_i, "awtrix")
+				}
+			}
+			cfg.DispatcherEntries[e_i].TopicsToPublish[t_i].ResolvedAwtrix = mergeAwtrix(e.Awtrix, t.Awtrix)
 		}
 	}
 
@@ -56,7 +177,8 @@ func LoadConfig(path string) (*RootConfig, error) {
 		switch fallbackMode(fb.Mode) {
 		case FallbackModeNone, FallbackModeNoValueRead, FallbackModeNoValueChange, "":
 		default:
-			return nil, fmt.Errorf("ERROR: INVALID FALLBACK MODE INDEX %d: '%s'", e_i, fb.Mode)
+			v.entryError(e_i, fmt.Errorf("ERROR: INVALID FALLBACK MODE INDEX %d: '%s'", e_i, fb.Mode), "fallback", "mode")
+			continue
 		}
 
 		if fb.Mode == "" || fallbackMode(fb.Mode) == FallbackModeNone {
@@ -65,17 +187,26 @@ func LoadConfig(path string) (*RootConfig, error) {
 
 		dur, err := time.ParseDuration(fb.After)
 		if err != nil {
-			return nil, fmt.Errorf("ERROR PARSING FALLBACK DURATION INDEX %d: %v", e_i, err)
-		}
-		if dur <= 0 {
-			return nil, fmt.Errorf("ERROR: FALLBACK DURATION MUST BE POSITIVE INDEX %d: '%s'", e_i, fb.After)
+			v.entryError(e_i, fmt.Errorf("ERROR PARSING FALLBACK DURATION INDEX %d: %v", e_i, err), "fallback", "after")
+		} else if dur <= 0 {
+			v.entryError(e_i, fmt.Errorf("ERROR: FALLBACK DURATION MUST BE POSITIVE INDEX %d: '%s'", e_i, fb.After), "fallback", "after")
 		}
 		cfg.DispatcherEntries[e_i].FallbackAfter = dur
 
 		if !isValidHexColor(fb.Color) {
-			return nil, fmt.Errorf("ERROR: INVALID FALLBACK COLOR INDEX %d: '%s'", e_i, fb.Color)
+			v.entryError(e_i, fmt.Errorf("ERROR: INVALID FALLBACK COLOR INDEX %d: '%s'", e_i, fb.Color), "fallback", "color")
 		}
 	}
 
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	cfg.warnings = v.warnings()
 	return &cfg, nil
 }
+
+// Warnings returns the problems of the config that don't prevent running it,
+// e.g. entries without source.
+func (c *RootConfig) Warnings() []Problem {
+	return c.warnings
+}
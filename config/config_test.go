@@ -1,6 +1,13 @@
 package config
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"net/url"
 	"os"
 	"testing"
@@ -74,6 +81,35 @@ dispatcher-entries:
 			expectedErrorMessage: "parse \"://invalid_url\": missing protocol scheme",
 			expectedConfig:       nil,
 		},
+		{
+			name: "UnsupportedBrokerScheme",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "http://localhost:1883"
+dispatcher-entries:
+  - operation: "none"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: UNSUPPORTED BROKER SCHEME: 'http'",
+			expectedConfig:       nil,
+		},
+		{
+			name: "TlsOptionsWithoutTlsScheme",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+  insecure-skip-verify: true
+dispatcher-entries:
+  - operation: "none"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: TLS OPTIONS REQUIRE A TLS BROKER SCHEME",
+			expectedConfig:       nil,
+		},
 		{
 			name: "InvalidOperation",
 			mockReadFile: func(path string) ([]byte, error) {
@@ -104,6 +140,317 @@ dispatcher-entries:
 			expectedErrorMessage: "ERROR RUNNING SCRIPT",
 			expectedConfig:       nil,
 		},
+		{
+			name: "OperationWithValueScript",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - operation: "sum"
+    value-script: |
+      function get_value(values) { return 1; }
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: OPERATION AND VALUE-SCRIPT ARE EXCLUSIVE INDEX 0",
+			expectedConfig:       nil,
+		},
+		{
+			name: "ValueScriptError",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - value-script: |
+      function get_value(values) { return values["missing"] * 2; }
+    source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "grid"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR CREATING VALUE CALLBACK FOR CONFIG 0",
+			expectedConfig:       nil,
+		},
+		{
+			name: "InvalidValueType",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "door"
+            transform:
+              value-type: "text"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: INVALID VALUE-TYPE 'text' INDEX 0",
+			expectedConfig:       nil,
+		},
+		{
+			name: "MappingOnNumber",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "door"
+            transform:
+              mapping:
+                "1": "ON"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: MAPPING REQUIRES VALUE-TYPE STRING OR BOOL INDEX 0",
+			expectedConfig:       nil,
+		},
+		{
+			name: "TextValuesAccumulated",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "door1"
+            transform:
+              value-type: "string"
+          - topic: "door2"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: TEXT VALUES CANNOT BE ACCUMULATED INDEX 0",
+			expectedConfig:       nil,
+		},
+		{
+			name: "TextValuesFiltered",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "door"
+            transform:
+              value-type: "bool"
+    topics-to-publish:
+      - topic: "awtrix/custom/door"
+        filter:
+          ignore-less-than: 1
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: TEXT VALUES CANNOT BE FILTERED INDEX 0",
+			expectedConfig:       nil,
+		},
+		{
+			name: "OutputFragmentsWithoutValue",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - topics-to-publish:
+      - topic: "awtrix/custom/power"
+        transform:
+          output-fragments:
+            - text: "W"
+              color: "#FFFFFF"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: OUTPUT-FRAGMENTS NEED EXACTLY ONE VALUE FRAGMENT, GOT 0 INDEX 0",
+			expectedConfig:       nil,
+		},
+		{
+			name: "InvalidFragmentColor",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - topics-to-publish:
+      - topic: "awtrix/custom/power"
+        transform:
+          output-fragments:
+            - value: true
+            - text: " W"
+              color: "white"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: INVALID FRAGMENT COLOR: 'white' INDEX 0",
+			expectedConfig:       nil,
+		},
+		{
+			name: "InvalidHistoryChartStyle",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - topics-to-publish:
+      - topic: "awtrix/custom/power"
+        transform:
+          output-as-history-chart:
+            style: "pie"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: INVALID HISTORY CHART STYLE 'pie' INDEX 0",
+			expectedConfig:       nil,
+		},
+		{
+			name: "InvalidHistoryChartDuration",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - topics-to-publish:
+      - topic: "awtrix/custom/power"
+        transform:
+          output-as-history-chart:
+            style: "bar"
+            duration: "30min"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: INVALID HISTORY CHART DURATION '30min' INDEX 0",
+			expectedConfig:       nil,
+		},
+		{
+			name: "InvalidStateInterval",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+state:
+  file: "/data/state.json"
+  interval: "1min"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: INVALID STATE INTERVAL '1min'",
+			expectedConfig:       nil,
+		},
+		{
+			name: "InvalidLoggingLevel",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+logging:
+  level: "verbose"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: INVALID LOGGING LEVEL 'verbose'",
+			expectedConfig:       nil,
+		},
+		{
+			name: "InvalidLoggingFormat",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+logging:
+  format: "logfmt"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: INVALID LOGGING FORMAT 'logfmt'",
+			expectedConfig:       nil,
+		},
+		{
+			name: "SourceMaxAgeWithoutAccumulation",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - source-max-age: "5m"
+    source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "solar"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: SOURCE-MAX-AGE REQUIRES MULTIPLE SOURCES OR A VALUE-SCRIPT INDEX 0",
+			expectedConfig:       nil,
+		},
+		{
+			name: "InvalidSourceExpiry",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - source-max-age: "5m"
+    source-expiry: "ignore"
+    operation: "sum"
+    source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "solar"
+          - topic: "grid"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: INVALID SOURCE-EXPIRY 'ignore' INDEX 0",
+			expectedConfig:       nil,
+		},
+		{
+			name: "InvalidHttpServerListen",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+http-server:
+  listen: "9100"
+  metrics: true
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: INVALID HTTP-SERVER LISTEN ADDRESS '9100'",
+			expectedConfig:       nil,
+		},
+		{
+			name: "InvalidBoolMappingKey",
+			mockReadFile: func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "switch"
+            transform:
+              value-type: "bool"
+              mapping:
+                "on": "ON"
+`), nil
+			},
+			expectedError:        true,
+			expectedErrorMessage: "ERROR: BOOL MAPPING KEYS MUST BE 'true' OR 'false', GOT 'on' INDEX 0",
+			expectedConfig:       nil,
+		},
 		{
 			name: "InvalidFallbackMode",
 			mockReadFile: func(path string) ([]byte, error) {
@@ -228,3 +575,159 @@ dispatcher-entries:
 		assert.Equal(t, time.Duration(0), entry.FallbackAfter)
 	})
 }
+
+// selfSignedPEM returns a freshly generated self-signed certificate and its key as PEM.
+func selfSignedPEM(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return certPEM, keyPEM
+}
+
+func TestLoadConfigTLS(t *testing.T) {
+	certPEM, keyPEM := selfSignedPEM(t)
+
+	mockFiles := func(cfg string) func(path string) ([]byte, error) {
+		return func(path string) ([]byte, error) {
+			switch path {
+			case "ca.pem", "client.pem":
+				return certPEM, nil
+			case "client.key":
+				return keyPEM, nil
+			case "broken.pem":
+				return []byte("not a certificate"), nil
+			case "dummy_path":
+				return []byte(cfg), nil
+			}
+			return nil, os.ErrNotExist
+		}
+	}
+
+	t.Run("MqttsWithCaAndClientCert", func(t *testing.T) {
+		osReadFile = mockFiles(`
+mqtt:
+  broker: "mqtts://localhost:8883"
+  ca-file: "ca.pem"
+  client-cert-file: "client.pem"
+  client-key-file: "client.key"
+`)
+		cfg, err := LoadConfig("dummy_path")
+		assert.NoError(t, err)
+		assert.True(t, cfg.Mqtt.UsesTLS())
+		assert.NotNil(t, cfg.Mqtt.TLSConfig)
+		assert.NotNil(t, cfg.Mqtt.TLSConfig.RootCAs)
+		assert.Len(t, cfg.Mqtt.TLSConfig.Certificates, 1)
+		assert.False(t, cfg.Mqtt.TLSConfig.InsecureSkipVerify)
+	})
+
+	t.Run("WssWithInsecureSkipVerify", func(t *testing.T) {
+		osReadFile = mockFiles(`
+mqtt:
+  broker: "wss://localhost:443/mqtt"
+  insecure-skip-verify: true
+`)
+		cfg, err := LoadConfig("dummy_path")
+		assert.NoError(t, err)
+		assert.Equal(t, "/mqtt", cfg.Mqtt.BrokerAsUri.Path)
+		assert.True(t, cfg.Mqtt.TLSConfig.InsecureSkipVerify)
+	})
+
+	t.Run("PlainWebsocketHasNoTLS", func(t *testing.T) {
+		osReadFile = mockFiles(`
+mqtt:
+  broker: "ws://localhost:9001"
+`)
+		cfg, err := LoadConfig("dummy_path")
+		assert.NoError(t, err)
+		assert.False(t, cfg.Mqtt.UsesTLS())
+		assert.Nil(t, cfg.Mqtt.TLSConfig)
+	})
+
+	t.Run("InvalidCaFile", func(t *testing.T) {
+		osReadFile = mockFiles(`
+mqtt:
+  broker: "mqtts://localhost:8883"
+  ca-file: "broken.pem"
+`)
+		cfg, err := LoadConfig("dummy_path")
+		assert.Nil(t, cfg)
+		assert.ErrorContains(t, err, "ERROR: NO CERTIFICATES FOUND IN CA FILE 'broken.pem'")
+	})
+
+	t.Run("MissingCaFile", func(t *testing.T) {
+		osReadFile = mockFiles(`
+mqtt:
+  broker: "mqtts://localhost:8883"
+  ca-file: "missing.pem"
+`)
+		cfg, err := LoadConfig("dummy_path")
+		assert.Nil(t, cfg)
+		assert.ErrorContains(t, err, "ERROR READING CA FILE 'missing.pem'")
+	})
+
+	t.Run("ClientCertWithoutKey", func(t *testing.T) {
+		osReadFile = mockFiles(`
+mqtt:
+  broker: "mqtts://localhost:8883"
+  client-cert-file: "client.pem"
+`)
+		cfg, err := LoadConfig("dummy_path")
+		assert.Nil(t, cfg)
+		assert.ErrorContains(t, err, "ERROR: CLIENT-CERT-FILE AND CLIENT-KEY-FILE MUST BE SET TOGETHER")
+	})
+}
+
+func TestEntryFingerprint(t *testing.T) {
+	a := Entry{Name: "a", Icon: "sun", Operation: "sum"}
+	b := a
+	b.ColorScriptCallback = func(float64) (string, error) { return "#FFFFFF", nil }
+	b.FallbackAfter = time.Hour
+	assert.Equal(t, a.Fingerprint(), b.Fingerprint(), "late binding fields must not change the fingerprint")
+
+	c := a
+	c.Icon = "moon"
+	assert.NotEqual(t, a.Fingerprint(), c.Fingerprint())
+}
+
+func TestLoadConfigTextColorScript(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "door"
+            transform:
+              jsonPath: "$.state"
+              value-type: "string"
+    color-script: |
+      function get_color(v) { return v == "open" ? "#FF0000" : "#00FF00"; }
+`), nil
+	}
+
+	cfg, err := LoadConfig("dummy_path")
+	assert.NoError(t, err)
+	entry := cfg.DispatcherEntries[0]
+	assert.True(t, entry.HasTextValue())
+	assert.Nil(t, entry.ColorScriptCallback)
+	assert.NotNil(t, entry.TextColorCallback)
+	color, err := entry.TextColorCallback("open")
+	assert.NoError(t, err)
+	assert.Equal(t, "#FF0000", color)
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type sourceExpiry string
+
+const (
+	// SourceExpiryDrop leaves expired sources out of the accumulation (default).
+	SourceExpiryDrop sourceExpiry = "drop"
+	// SourceExpiryZero accumulates expired sources as 0.
+	SourceExpiryZero sourceExpiry = "zero"
+	// SourceExpiryWait publishes nothing until all sources are fresh again.
+	SourceExpiryWait sourceExpiry = "wait"
+)
+
+// SourceExpiry returns the configured behavior for expired sources.
+func (e Entry) SourceExpiry() sourceExpiry {
+	if e.SourceExpiryMode == "" {
+		return SourceExpiryDrop
+	}
+	return sourceExpiry(e.SourceExpiryMode)
+}
+
+// validateSourceExpiry checks source-max-age and source-expiry and parses the max age.
+func validateSourceExpiry(e *Entry) error {
+	if e.SourceMaxAge == "" {
+		if e.SourceExpiryMode != "" {
+			return errors.New("ERROR: SOURCE-EXPIRY REQUIRES SOURCE-MAX-AGE")
+		}
+		return nil
+	}
+
+	if must, _ := e.MustAccumulate(); !must {
+		return errors.New("ERROR: SOURCE-MAX-AGE REQUIRES MULTIPLE SOURCES OR A VALUE-SCRIPT")
+	}
+	switch e.SourceExpiry() {
+	case SourceExpiryDrop, SourceExpiryZero, SourceExpiryWait:
+	default:
+		return fmt.Errorf("ERROR: INVALID SOURCE-EXPIRY '%s'", e.SourceExpiryMode)
+	}
+
+	d, err := time.ParseDuration(e.SourceMaxAge)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("ERROR: INVALID SOURCE-MAX-AGE '%s'", e.SourceMaxAge)
+	}
+	e.SourceMaxAgeParsed = d
+	return nil
+}
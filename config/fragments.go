@@ -0,0 +1,62 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TextFragment is one part of a text published as Awtrix fragments
+// ({"t": ..., "c": ...}). Exactly one fragment of output-fragments has value
+// set, it gets the formatted value as text and the color of the color-script.
+// All others are static text with their own color.
+type TextFragment struct {
+	Text  string `yaml:"text,omitempty" json:"t"`
+	Color string `yaml:"color,omitempty" json:"c,omitempty"`
+	Value bool   `yaml:"value,omitempty" json:"-"`
+}
+
+func (m MqttTopicDefinition) GetOutputFragments() []TextFragment {
+	return m.Transform.OutputFragments
+}
+
+func (h HttpUrlDefinition) GetOutputFragments() []TextFragment {
+	return h.Transform.OutputFragments
+}
+
+// validateFragments checks the output-fragments of the publish topics.
+func validateFragments(e Entry) error {
+	for _, t := range e.SourceTransforms() {
+		if len(t.OutputFragments) > 0 {
+			return errors.New("ERROR: OUTPUT-FRAGMENTS ARE ONLY ALLOWED ON PUBLISH TOPICS")
+		}
+	}
+
+	for _, p := range e.TopicsToPublish {
+		fragments := p.Transform.OutputFragments
+		if len(fragments) == 0 {
+			continue
+		}
+		if p.Transform.OutputAsTibberGraph {
+			return errors.New("ERROR: OUTPUT-FRAGMENTS AND OUTPUT-AS-TIBBER-GRAPH ARE EXCLUSIVE")
+		}
+		values := 0
+		for _, f := range fragments {
+			switch {
+			case f.Value:
+				values++
+				if f.Text != "" {
+					return errors.New("ERROR: VALUE FRAGMENT CANNOT HAVE TEXT")
+				}
+			case f.Text == "":
+				return errors.New("ERROR: STATIC FRAGMENT NEEDS TEXT")
+			}
+			if f.Color != "" && !isValidHexColor(f.Color) {
+				return fmt.Errorf("ERROR: INVALID FRAGMENT COLOR: '%s'", f.Color)
+			}
+		}
+		if values != 1 {
+			return fmt.Errorf("ERROR: OUTPUT-FRAGMENTS NEED EXACTLY ONE VALUE FRAGMENT, GOT %d", values)
+		}
+	}
+	return nil
+}
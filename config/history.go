@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	HistoryChartBar  = "bar"
+	HistoryChartLine = "line"
+	HistoryChartDraw = "draw"
+
+	// historyDrawColumns is the width of the Awtrix matrix, the most samples a drawn chart can show.
+	historyDrawColumns = 32
+)
+
+// HistoryChartDefinition publishes the rolling history of the resolved value
+// as chart. Samples limits the history to the last n values, Duration to the
+// values of the given time span; without both the chart is filled up.
+type HistoryChartDefinition struct {
+	Style    string `yaml:"style"`
+	Samples  int    `yaml:"samples,omitempty"`
+	Duration string `yaml:"duration,omitempty"`
+
+	// Late binding
+	MaxAge time.Duration `yaml:"-"`
+}
+
+// MaxSamples returns the number of values the chart shows.
+func (h HistoryChartDefinition) MaxSamples() int {
+	max := awtrixMaxChartValues
+	if h.Style == HistoryChartDraw {
+		max = historyDrawColumns
+	}
+	if h.Samples > 0 && h.Samples < max {
+		return h.Samples
+	}
+	return max
+}
+
+func (m MqttTopicDefinition) GetOutputAsHistoryChart() *HistoryChartDefinition {
+	return m.Transform.OutputAsHistoryChart
+}
+
+func (h HttpUrlDefinition) GetOutputAsHistoryChart() *HistoryChartDefinition {
+	return h.Transform.OutputAsHistoryChart
+}
+
+// validateHistoryCharts checks the output-as-history-chart of the publish
+// topics and parses their duration.
+func validateHistoryCharts(e *Entry) error {
+	for _, t := range e.SourceTransforms() {
+		if t.OutputAsHistoryChart != nil {
+			return errors.New("ERROR: OUTPUT-AS-HISTORY-CHART IS ONLY ALLOWED ON PUBLISH TOPICS")
+		}
+	}
+
+	for i, p := range e.TopicsToPublish {
+		h := p.Transform.OutputAsHistoryChart
+		if h == nil {
+			continue
+		}
+		if e.HasTextValue() {
+			return errors.New("ERROR: TEXT VALUES CANNOT BE CHARTED")
+		}
+		if p.Transform.OutputAsTibberGraph || len(p.Transform.OutputFragments) > 0 {
+			return errors.New("ERROR: OUTPUT-AS-HISTORY-CHART, OUTPUT-AS-TIBBER-GRAPH AND OUTPUT-FRAGMENTS ARE EXCLUSIVE")
+		}
+		switch h.Style {
+		case HistoryChartBar, HistoryChartLine, HistoryChartDraw:
+		default:
+			return fmt.Errorf("ERROR: INVALID HISTORY CHART STYLE '%s'", h.Style)
+		}
+		if h.Samples < 0 {
+			return fmt.Errorf("ERROR: INVALID HISTORY CHART SAMPLES %d", h.Samples)
+		}
+		if h.Duration != "" {
+			d, err := time.ParseDuration(h.Duration)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("ERROR: INVALID HISTORY CHART DURATION '%s'", h.Duration)
+			}
+			e.TopicsToPublish[i].Transform.OutputAsHistoryChart.MaxAge = d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"errors"
+	"net"
+)
+
+// HttpServerConfig enables the http listener of the dispatcher.
+type HttpServerConfig struct {
+	Listen    string `yaml:"listen"`
+	API       bool   `yaml:"api,omitempty"`
+	Dashboard bool   `yaml:"dashboard,omitempty"`
+	Metrics   bool   `yaml:"metrics,omitempty"`
+}
+
+// validateHttpServer checks that listen is a host:port address, e.g. ":9100".
+func validateHttpServer(h *HttpServerConfig) error {
+	if _, _, err := net.SplitHostPort(h.Listen); err != nil {
+		return errors.New("ERROR: INVALID HTTP-SERVER LISTEN ADDRESS '" + h.Listen + "'")
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configLoader merges a config file, the files matched by its include globs and
+// the *.yaml and *.yml files of a config directory into one config.
+type configLoader struct {
+	cfg       RootConfig
+	v         *validator
+	templates map[string]yaml.MapSlice
+	// sections maps a section and a color-script or template name to the file
+	// which defined it, to report duplicates
+	sections map[string]string
+	loaded   map[string]bool
+}
+
+func newConfigLoader() *configLoader {
+	return &configLoader{
+		v:         newValidator(),
+		templates: map[string]yaml.MapSlice{},
+		sections:  map[string]string{},
+		loaded:    map[string]bool{},
+	}
+}
+
+// load loads path, expands the templates of the entries and checks that the
+// entry names are unique. Only unreadable and malformed files are returned as
+// error, the other problems are collected by the validator.
+func (l *configLoader) load(path string) (*RootConfig, error) {
+	if err := l.loadPath(path); err != nil {
+		return nil, err
+	}
+
+	for e_i, e := range l.cfg.DispatcherEntries {
+		if e.UseTemplate == "" {
+			if len(e.Variables) > 0 {
+				l.v.entryError(e_i, fmt.Errorf("ERROR: VARIABLES REQUIRE USE-TEMPLATE INDEX %d", e_i), "variables")
+			}
+			continue
+		}
+		tpl, ok := l.templates[e.UseTemplate]
+		if !ok {
+			l.v.entryError(e_i, fmt.Errorf("ERROR: UNKNOWN TEMPLATE '%s' INDEX %d", e.UseTemplate, e_i), "use-template")
+			continue
+		}
+		expanded, err := expandTemplate(tpl, e)
+		if err != nil {
+			l.v.entryError(e_i, fmt.Errorf("%v INDEX %d", err, e_i), "use-template")
+			continue
+		}
+		l.cfg.DispatcherEntries[e_i] = expanded
+	}
+
+	// Entries are identified by name in the dispatcher state
+	names := map[string]int{}
+	for e_i, e := range l.cfg.DispatcherEntries {
+		if e.Name == "" {
+			continue
+		}
+		if first, ok := names[e.Name]; ok {
+			l.v.entryError(e_i, fmt.Errorf("ERROR: DUPLICATE ENTRY NAME '%s', FIRST DEFINED AT %s INDEX %d", e.Name, l.v.entryLine(first), e_i), "name")
+			continue
+		}
+		names[e.Name] = e_i
+	}
+	return &l.cfg, nil
+}
+
+// loadPath loads path, a config file or a directory of config files.
+func (l *configLoader) loadPath(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.IsDir() {
+		// A missing file is reported by osReadFile
+		return l.loadFile(path)
+	}
+
+	l.cfg.files = append(l.cfg.files, path)
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			return err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		if err := l.loadFile(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFile merges the file into the config and loads its includes, relative to
+// the directory of the file. Files are loaded once, so cyclic includes are fine.
+func (l *configLoader) loadFile(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if l.loaded[abs] {
+		return nil
+	}
+	l.loaded[abs] = true
+
+	data, err := osReadFile(path)
+	if err != nil {
+		return err
+	}
+	var c RootConfig
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		// Unknown keys, e.g. typos, are problems, the other keys are decoded
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) || !l.v.addUnknownKeys(path, typeErr.Errors) {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	l.cfg.files = append(l.cfg.files, path)
+	l.v.parse(path, data)
+
+	l.merge(path, c)
+
+	for i_i, include := range c.Include {
+		pattern := include
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			l.v.add(path, false, fmt.Errorf("ERROR: INVALID INCLUDE '%s': %v", include, err), "include", i_i)
+			continue
+		}
+		// The directory of a glob is watched, so files added later are loaded
+		if dir := filepath.Dir(pattern); hasGlobMeta(filepath.Base(pattern)) && !hasGlobMeta(dir) && !slices.Contains(l.cfg.files, dir) {
+			l.cfg.files = append(l.cfg.files, dir)
+		}
+		if len(matches) == 0 {
+			l.v.add(path, false, fmt.Errorf("ERROR: INCLUDE '%s' MATCHES NO FILES", include), "include", i_i)
+			continue
+		}
+		for _, m := range matches {
+			if err := l.loadPath(m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hasGlobMeta reports whether path contains the special characters of
+// filepath.Match.
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// merge adds the sections of c, loaded from path, to the config. The mqtt,
+// logging, state and http-server sections may be defined in one file only and
+// broker, color-script and template names must be unique across all files.
+func (l *configLoader) merge(path string, c RootConfig) {
+	// define reports whether key is defined for the first time
+	define := func(key, kind string, node ...interface{}) bool {
+		if other, ok := l.sections[key]; ok {
+			l.v.add(path, false, fmt.Errorf("ERROR: DUPLICATE %s, FIRST DEFINED IN '%s'", kind, other), node...)
+			return false
+		}
+		l.sections[key] = path
+		return true
+	}
+
+	if c.Mqtt != (MqttConfig{}) && define("mqtt", "MQTT SECTION", "mqtt") {
+		l.cfg.Mqtt = c.Mqtt
+	}
+	if c.Logging != nil && define("logging", "LOGGING SECTION", "logging") {
+		l.cfg.Logging = c.Logging
+	}
+	if c.State != nil && define("state", "STATE SECTION", "state") {
+		l.cfg.State = c.State
+	}
+	if c.HttpServer != nil && define("http-server", "HTTP-SERVER SECTION", "http-server") {
+		l.cfg.HttpServer = c.HttpServer
+	}
+
+	for name, script := range c.ColorScripts {
+		if !define("color-script\x00"+name, fmt.Sprintf("COLOR-SCRIPT '%s'", name), "color-scripts", name) {
+			continue
+		}
+		if l.cfg.ColorScripts == nil {
+			l.cfg.ColorScripts = map[string]string{}
+		}
+		l.cfg.ColorScripts[name] = script
+	}
+
+	for name, tpl := range c.Templates {
+		if define("template\x00"+name, fmt.Sprintf("TEMPLATE '%s'", name), "templates", name) {
+			l.templates[name] = tpl
+		}
+	}
+
+	for i, b := range c.Brokers {
+		if b.Name != "" && !define("broker\x00"+b.Name, fmt.Sprintf("BROKER '%s'", b.Name), "brokers", i, "name") {
+			continue
+		}
+		l.cfg.Brokers = append(l.cfg.Brokers, b)
+		l.v.brokers = append(l.v.brokers, entryPosition{file: path, index: i})
+	}
+
+	for i, e := range c.DispatcherEntries {
+		l.cfg.DispatcherEntries = append(l.cfg.DispatcherEntries, e)
+		l.v.entries = append(l.v.entries, entryPosition{file: path, index: i})
+	}
+}
+
+// Files returns the config files and directories the config was loaded from,
+// to watch them for changes.
+func (c *RootConfig) Files() []string {
+	return c.files
+}
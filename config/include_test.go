@@ -0,0 +1,168 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	osReadFile = os.ReadFile
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yaml": `
+include:
+  - "rooms/*.yaml"
+  - "scripts.yaml"
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - name: "house"
+    color-script: "traffic-light"
+`,
+		"scripts.yaml": `
+color-scripts:
+  traffic-light: |
+    function get_color(v) { return v > 100 ? "#FF0000" : "#00FF00"; }
+`,
+		"rooms/kitchen.yaml": `
+dispatcher-entries:
+  - name: "kitchen"
+    color-script: "traffic-light"
+`,
+		"rooms/office.yaml": `
+include:
+  - "../config.yaml"
+dispatcher-entries:
+  - name: "office"
+    color-script: |
+      function get_color(v) { return "#FFFFFF"; }
+`,
+	})
+
+	cfg, err := LoadConfig(filepath.Join(dir, "config.yaml"))
+	require.NoError(t, err)
+	var names []string
+	for _, e := range cfg.DispatcherEntries {
+		names = append(names, e.Name)
+	}
+	assert.Equal(t, []string{"house", "kitchen", "office"}, names)
+	for _, e := range cfg.DispatcherEntries[:2] {
+		color, err := e.ColorScriptCallback(150)
+		assert.NoError(t, err)
+		assert.Equal(t, "#FF0000", color)
+	}
+	// The files and the directory of the rooms/*.yaml glob
+	assert.Len(t, cfg.Files(), 5)
+	assert.Contains(t, cfg.Files(), filepath.Join(dir, "rooms"))
+
+	// A directory is loaded like a file including all its files
+	cfg, err = LoadConfig(filepath.Join(dir, "rooms"))
+	require.NoError(t, err)
+	assert.Len(t, cfg.DispatcherEntries, 3)
+	assert.Equal(t, filepath.Join(dir, "rooms"), cfg.Files()[0])
+}
+
+func TestLoadConfigIncludeErrors(t *testing.T) {
+	osReadFile = os.ReadFile
+	tests := []struct {
+		name                 string
+		files                map[string]string
+		expectedErrorMessage string
+	}{
+		{
+			name: "DuplicateEntryName",
+			files: map[string]string{
+				"config.yaml": `
+include: ["a.yaml"]
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - name: "power"
+`,
+				"a.yaml": `
+dispatcher-entries:
+  - name: "power"
+`,
+			},
+			expectedErrorMessage: "DIR/a.yaml:3: ERROR: DUPLICATE ENTRY NAME 'power', FIRST DEFINED AT DIR/config.yaml:6 INDEX 1",
+		},
+		{
+			name: "DuplicateMqttSection",
+			files: map[string]string{
+				"config.yaml": `
+include: ["a.yaml"]
+mqtt:
+  broker: "tcp://localhost:1883"
+`,
+				"a.yaml": `
+mqtt:
+  broker: "tcp://other:1883"
+`,
+			},
+			expectedErrorMessage: "DIR/a.yaml:2: ERROR: DUPLICATE MQTT SECTION, FIRST DEFINED IN 'DIR/config.yaml'",
+		},
+		{
+			name: "DuplicateColorScript",
+			files: map[string]string{
+				"config.yaml": `
+include: ["a.yaml"]
+mqtt:
+  broker: "tcp://localhost:1883"
+color-scripts:
+  white: "function get_color(v) { return '#FFFFFF'; }"
+`,
+				"a.yaml": `
+color-scripts:
+  white: "function get_color(v) { return '#FFFFFF'; }"
+`,
+			},
+			expectedErrorMessage: "DIR/a.yaml:3: ERROR: DUPLICATE COLOR-SCRIPT 'white', FIRST DEFINED IN 'DIR/config.yaml'",
+		},
+		{
+			name: "IncludeMatchesNothing",
+			files: map[string]string{
+				"config.yaml": `
+include: ["rooms/*.yaml"]
+mqtt:
+  broker: "tcp://localhost:1883"
+`,
+			},
+			expectedErrorMessage: "DIR/config.yaml:2: ERROR: INCLUDE 'rooms/*.yaml' MATCHES NO FILES",
+		},
+		{
+			name: "UnknownColorScript",
+			files: map[string]string{
+				"config.yaml": `
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - color-script: "traffic-light"
+`,
+			},
+			expectedErrorMessage: "DIR/config.yaml:5: ERROR: UNKNOWN COLOR-SCRIPT 'traffic-light' INDEX 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			cfg, err := LoadConfig(filepath.Join(dir, "config.yaml"))
+			assert.Nil(t, cfg)
+			assert.ErrorContains(t, err, strings.ReplaceAll(tt.expectedErrorMessage, "DIR", dir))
+		})
+	}
+}
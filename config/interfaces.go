@@ -29,3 +29,23 @@ type MqttEntry interface {
 	GetName() string
 	GetTypeName() string
 }
+
+// EntryIdentity is implemented by every typed entry.
+type EntryIdentity interface {
+	GetID() string
+	GetName() string
+	GetTypeName() string
+}
+
+// Identity returns the typed entry of its source, false if the entry has no source.
+func (e Entry) Identity() (EntryIdentity, bool) {
+	switch {
+	case e.Source.MqttSource != nil && len(e.Source.MqttSource.TopicsToSubscribe) > 0:
+		return MqttEntryImpl{Entry: e}, true
+	case e.Source.HttpSource != nil && len(e.Source.HttpSource.Urls) > 0:
+		return HttpEntryImpl{Entry: e}, true
+	case e.Source.TibberApiSource != nil:
+		return TibberApiEntryImpl{Entry: e}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"go-mqtt-dispatcher/logging"
+	"strings"
+)
+
+// LoggingConfig sets the log level (debug, info, warn, error) and format (text,
+// json). Entries with debug set log at debug level regardless of level.
+type LoggingConfig struct {
+	Level  string `yaml:"level,omitempty"`
+	Format string `yaml:"format,omitempty"`
+}
+
+func validateLogging(l *LoggingConfig) error {
+	if _, err := logging.ParseLevel(l.Level); err != nil {
+		return fmt.Errorf("ERROR: INVALID LOGGING LEVEL '%s'", l.Level)
+	}
+	switch strings.ToLower(l.Format) {
+	case "", logging.FormatText, logging.FormatJson:
+	default:
+		return fmt.Errorf("ERROR: INVALID LOGGING FORMAT '%s'", l.Format)
+	}
+	return nil
+}
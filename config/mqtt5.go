@@ -0,0 +1,111 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Supported values of protocol-version.
+const (
+	ProtocolVersion311 = 4
+	ProtocolVersion5   = 5
+)
+
+// sharePrefix starts the filter of a shared subscription, $share/group/topic.
+const sharePrefix = "$share/"
+
+// IsMqtt5 reports whether the broker is connected with MQTT 5.
+func (m MqttConfig) IsMqtt5() bool {
+	return m.ProtocolVersion == ProtocolVersion5
+}
+
+func validateProtocolVersion(m MqttConfig) error {
+	switch m.ProtocolVersion {
+	case 0, ProtocolVersion311, ProtocolVersion5:
+		return nil
+	}
+	return fmt.Errorf("ERROR: PROTOCOL-VERSION MUST BE 4 (MQTT 3.1.1) OR 5 (MQTT 5): %d", m.ProtocolVersion)
+}
+
+// SubscribeFilter returns the filter to subscribe the topic with, the topic or
+// $share/group/topic for a shared subscription.
+func (t MqttTopicDefinition) SubscribeFilter() string {
+	if t.ShareGroup == "" {
+		return t.Topic
+	}
+	return sharePrefix + t.ShareGroup + "/" + t.Topic
+}
+
+// validateMqtt5 checks the MQTT 5 options of the topics of the entries, which
+// need a broker with protocol-version 5, and parses the message expiries.
+func validateMqtt5(cfg *RootConfig, v *validator) {
+	for e_i := range cfg.DispatcherEntries {
+		e := &cfg.DispatcherEntries[e_i]
+		if e.Source.MqttSource != nil {
+			for t_i, t := range e.Source.MqttSource.TopicsToSubscribe {
+				path := []interface{}{"source", "mqtt", "topics-to-subscribe", t_i}
+				if strings.ContainsAny(t.ShareGroup, "/+#") {
+					v.entryError(e_i, fmt.Errorf("ERROR: SHARE-GROUP MUST NOT CONTAIN '/', '+' OR '#': '%s' INDEX %d", t.ShareGroup, e_i), append(path, "share-group")...)
+				}
+				b, _ := cfg.Broker(t.ResolvedBroker)
+				if t.NoLocal && !b.IsMqtt5() {
+					v.entryError(e_i, fmt.Errorf("ERROR: NO-LOCAL REQUIRES PROTOCOL-VERSION 5 INDEX %d", e_i), append(path, "no-local")...)
+				}
+				if t.NoLocal && t.ShareGroup != "" {
+					// a protocol error in MQTT 5, the broker would drop the connection
+					v.entryError(e_i, fmt.Errorf("ERROR: NO-LOCAL IS NOT ALLOWED WITH SHARE-GROUP INDEX %d", e_i), append(path, "no-local")...)
+				}
+				if t.hasPublishProperties() {
+					v.entryError(e_i, fmt.Errorf("ERROR: MESSAGE-EXPIRY, CONTENT-TYPE, USER-PROPERTIES, RESPONSE-TOPIC AND CORRELATION-DATA ARE ONLY ALLOWED ON PUBLISH TOPICS INDEX %d", e_i), path...)
+				}
+			}
+		}
+
+		for t_i, t := range e.TopicsToPublish {
+			path := []interface{}{"topics-to-publish", t_i}
+			if t.ShareGroup != "" || t.NoLocal {
+				v.entryError(e_i, fmt.Errorf("ERROR: SHARE-GROUP AND NO-LOCAL ARE ONLY ALLOWED ON SUBSCRIBE TOPICS INDEX %d TOPIC %d", e_i, t_i), path...)
+			}
+			b, _ := cfg.Broker(t.ResolvedBroker)
+			if !b.IsMqtt5() && t.hasPublishProperties() {
+				v.entryError(e_i, fmt.Errorf("ERROR: MESSAGE-EXPIRY, CONTENT-TYPE, USER-PROPERTIES, RESPONSE-TOPIC AND CORRELATION-DATA REQUIRE PROTOCOL-VERSION 5 INDEX %d TOPIC %d", e_i, t_i), path...)
+			}
+			if IsWildcard(t.ResponseTopic) || HasTopicPlaceholders(t.ResponseTopic) {
+				v.entryError(e_i, fmt.Errorf("ERROR: RESPONSE-TOPIC MUST NOT CONTAIN WILDCARDS OR PLACEHOLDERS: '%s' INDEX %d TOPIC %d", t.ResponseTopic, e_i, t_i), append(path, "response-topic")...)
+			}
+			if t.CorrelationData != "" && t.ResponseTopic == "" {
+				v.entryError(e_i, fmt.Errorf("ERROR: CORRELATION-DATA REQUIRES A RESPONSE-TOPIC INDEX %d TOPIC %d", e_i, t_i), append(path, "correlation-data")...)
+			}
+			if t.MessageExpiry == "" {
+				continue
+			}
+			d, err := parseMessageExpiry(t.MessageExpiry)
+			if err != nil {
+				v.entryError(e_i, fmt.Errorf("ERROR: INVALID MESSAGE-EXPIRY '%s' INDEX %d TOPIC %d", t.MessageExpiry, e_i, t_i), append(path, "message-expiry")...)
+				continue
+			}
+			e.TopicsToPublish[t_i].ResolvedMessageExpiry = d
+		}
+	}
+}
+
+// hasPublishProperties reports whether t sets MQTT 5 properties of the
+// published messages.
+func (t MqttTopicDefinition) hasPublishProperties() bool {
+	return t.MessageExpiry != "" || t.ContentType != "" || len(t.UserProperties) > 0 || t.ResponseTopic != "" || t.CorrelationData != ""
+}
+
+// parseMessageExpiry parses a positive duration of whole seconds, the unit of
+// the MQTT 5 message expiry interval.
+func parseMessageExpiry(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < time.Second || d%time.Second != 0 {
+		return 0, errors.New("message expiry must be whole seconds")
+	}
+	return d, nil
+}
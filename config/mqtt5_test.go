@@ -0,0 +1,99 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigMqtt5(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+  protocol-version: 5
+dispatcher-entries:
+  - name: "power"
+    source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "power"
+            share-group: "dispatchers"
+          - topic: "solar"
+            no-local: true
+    topics-to-publish:
+      - topic: "awtrix/custom/power"
+        message-expiry: "5m"
+        content-type: "application/json"
+        user-properties:
+          unit: "W"
+        response-topic: "awtrix/custom/power/reply"
+        correlation-data: "power"
+`), nil
+	}
+
+	cfg, err := LoadConfig("config.yaml")
+	require.NoError(t, err)
+	assert.True(t, cfg.Mqtt.IsMqtt5())
+
+	e := cfg.DispatcherEntries[0]
+	assert.Equal(t, "$share/dispatchers/power", e.Source.MqttSource.TopicsToSubscribe[0].SubscribeFilter())
+	assert.Equal(t, "solar", e.Source.MqttSource.TopicsToSubscribe[1].SubscribeFilter())
+	assert.True(t, e.Source.MqttSource.TopicsToSubscribe[1].NoLocal)
+	assert.Equal(t, 5*time.Minute, e.TopicsToPublish[0].ResolvedMessageExpiry)
+	assert.Equal(t, "application/json", e.TopicsToPublish[0].ContentType)
+	assert.Equal(t, map[string]string{"unit": "W"}, e.TopicsToPublish[0].UserProperties)
+	assert.Equal(t, "awtrix/custom/power/reply", e.TopicsToPublish[0].ResponseTopic)
+	assert.Equal(t, "power", e.TopicsToPublish[0].CorrelationData)
+
+	captures, ok := MatchTopic("$share/dispatchers/power/+", "power/kitchen")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"kitchen"}, captures)
+}
+
+func TestLoadConfigMqtt5Errors(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+  protocol-version: 3
+dispatcher-entries:
+  - name: "power"
+    source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "power"
+            share-group: "a/b"
+            no-local: true
+    topics-to-publish:
+      - topic: "awtrix/custom/power"
+        message-expiry: "1.5s"
+      - topic: "awtrix/custom/reply"
+        response-topic: "reply/+"
+      - topic: "awtrix/custom/other"
+        correlation-data: "abc"
+`), nil
+	}
+
+	cfg, err := LoadConfig("config.yaml")
+	assert.Nil(t, cfg)
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+
+	var problems []string
+	for _, p := range verr.Problems {
+		problems = append(problems, p.String())
+	}
+	assert.Equal(t, []string{
+		"config.yaml:3: ERROR: PROTOCOL-VERSION MUST BE 4 (MQTT 3.1.1) OR 5 (MQTT 5): 3",
+		"config.yaml:10: ERROR: SHARE-GROUP MUST NOT CONTAIN '/', '+' OR '#': 'a/b' INDEX 0",
+		"config.yaml:11: ERROR: NO-LOCAL REQUIRES PROTOCOL-VERSION 5 INDEX 0",
+		"config.yaml:11: ERROR: NO-LOCAL IS NOT ALLOWED WITH SHARE-GROUP INDEX 0",
+		"config.yaml:13: ERROR: MESSAGE-EXPIRY, CONTENT-TYPE, USER-PROPERTIES, RESPONSE-TOPIC AND CORRELATION-DATA REQUIRE PROTOCOL-VERSION 5 INDEX 0 TOPIC 0",
+		"config.yaml:14: ERROR: INVALID MESSAGE-EXPIRY '1.5s' INDEX 0 TOPIC 0",
+		"config.yaml:15: ERROR: MESSAGE-EXPIRY, CONTENT-TYPE, USER-PROPERTIES, RESPONSE-TOPIC AND CORRELATION-DATA REQUIRE PROTOCOL-VERSION 5 INDEX 0 TOPIC 1",
+		"config.yaml:16: ERROR: RESPONSE-TOPIC MUST NOT CONTAIN WILDCARDS OR PLACEHOLDERS: 'reply/+' INDEX 0 TOPIC 1",
+		"config.yaml:17: ERROR: MESSAGE-EXPIRY, CONTENT-TYPE, USER-PROPERTIES, RESPONSE-TOPIC AND CORRELATION-DATA REQUIRE PROTOCOL-VERSION 5 INDEX 0 TOPIC 2",
+		"config.yaml:18: ERROR: CORRELATION-DATA REQUIRES A RESPONSE-TOPIC INDEX 0 TOPIC 2",
+	}, problems)
+}
@@ -0,0 +1,56 @@
+package config
+
+// isValidOperator reports whether op is a known accumulation operation.
+func isValidOperator(op operator) bool {
+	switch op {
+	case OperatorNone, OperatorSum, OperatorAvg, OperatorMin, OperatorMax, OperatorCount, OperatorProduct, OperatorDiff:
+		return true
+	}
+	return false
+}
+
+// Accumulate combines the source values, which must be in configured source
+// order (see Entry.SourceIDs) because diff subtracts all values from the first.
+// ok is false for OperatorNone and an empty values slice.
+func (op operator) Accumulate(values []float64) (result float64, ok bool) {
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	switch op {
+	case OperatorSum:
+		for _, v := range values {
+			result += v
+		}
+	case OperatorAvg:
+		for _, v := range values {
+			result += v
+		}
+		result /= float64(len(values))
+	case OperatorMin:
+		result = values[0]
+		for _, v := range values[1:] {
+			result = min(result, v)
+		}
+	case OperatorMax:
+		result = values[0]
+		for _, v := range values[1:] {
+			result = max(result, v)
+		}
+	case OperatorCount:
+		result = float64(len(values))
+	case OperatorProduct:
+		result = 1
+		for _, v := range values {
+			result *= v
+		}
+	case OperatorDiff:
+		result = values[0]
+		for _, v := range values[1:] {
+			result -= v
+		}
+	default:
+		return 0, false
+	}
+	return result, true
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperatorAccumulate(t *testing.T) {
+	values := []float64{800, 300, 100}
+
+	tests := []struct {
+		op     operator
+		want   float64
+		wantOk bool
+	}{
+		{OperatorSum, 1200, true},
+		{OperatorAvg, 400, true},
+		{OperatorMin, 100, true},
+		{OperatorMax, 800, true},
+		{OperatorCount, 3, true},
+		{OperatorProduct, 24000000, true},
+		{OperatorDiff, 400, true},
+		{OperatorNone, 0, false},
+		{operator("invalid"), 0, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.op), func(t *testing.T) {
+			got, ok := tc.op.Accumulate(values)
+			assert.Equal(t, tc.wantOk, ok)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestOperatorAccumulateEdgeCases(t *testing.T) {
+	_, ok := OperatorSum.Accumulate(nil)
+	assert.False(t, ok, "no values")
+
+	got, ok := OperatorDiff.Accumulate([]float64{5})
+	assert.True(t, ok)
+	assert.Equal(t, 5.0, got, "diff of a single value is the value")
+
+	got, ok = OperatorMin.Accumulate([]float64{-3, 2, -7})
+	assert.True(t, ok)
+	assert.Equal(t, -7.0, got)
+}
+
+func TestLoadConfigOperators(t *testing.T) {
+	for _, op := range []string{"", "sum", "avg", "min", "max", "count", "product", "diff"} {
+		t.Run(op, func(t *testing.T) {
+			osReadFile = func(path string) ([]byte, error) {
+				return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - operation: "` + op + `"
+`), nil
+			}
+			cfg, err := LoadConfig("dummy_path")
+			assert.NoError(t, err)
+			assert.Equal(t, op, cfg.DispatcherEntries[0].Operation)
+		})
+	}
+}
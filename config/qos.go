@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+)
+
+// defaultRetain keeps the published values on the broker, so a restarted awtrix
+// shows them at once.
+const defaultRetain = true
+
+func validateQos(qos *int) error {
+	if qos != nil && (*qos < 0 || *qos > 2) {
+		return fmt.Errorf("ERROR: QOS MUST BE 0, 1 OR 2: %d", *qos)
+	}
+	return nil
+}
+
+// resolveQos returns the qos of a topic, or else the default of the broker or 0.
+func resolveQos(m MqttConfig, t MqttTopicDefinition) byte {
+	switch {
+	case t.Qos != nil:
+		return byte(*t.Qos)
+	case m.Qos != nil:
+		return byte(*m.Qos)
+	}
+	return 0
+}
+
+// resolveRetain returns the retain flag of a publish topic, or else the default
+// of the broker or true.
+func resolveRetain(m MqttConfig, t MqttTopicDefinition) bool {
+	switch {
+	case t.Retain != nil:
+		return *t.Retain
+	case m.Retain != nil:
+		return *m.Retain
+	}
+	return defaultRetain
+}
+
+// validateQosAndRetain checks the qos of the mqtt section and of all topics of
+// the entries and resolves them with the defaults of the broker of each topic,
+// retain is only allowed on publish topics.
+func validateQosAndRetain(cfg *RootConfig, v *validator, mqttFile string) {
+	if err := validateQos(cfg.Mqtt.Qos); err != nil {
+		v.add(mqttFile, false, err, "mqtt", "qos")
+	}
+
+	for e_i := range cfg.DispatcherEntries {
+		e := &cfg.DispatcherEntries[e_i]
+		if e.Source.MqttSource != nil {
+			for t_i, t := range e.Source.MqttSource.TopicsToSubscribe {
+				path := []interface{}{"source", "mqtt", "topics-to-subscribe", t_i}
+				if err := validateQos(t.Qos); err != nil {
+					v.entryError(e_i, fmt.Errorf("%v INDEX %d", err, e_i), append(path, "qos")...)
+				}
+				if t.Retain != nil {
+					v.entryError(e_i, fmt.Errorf("ERROR: RETAIN IS ONLY ALLOWED ON PUBLISH TOPICS INDEX %d", e_i), append(path, "retain")...)
+				}
+				b, _ := cfg.Broker(t.ResolvedBroker)
+				e.Source.MqttSource.TopicsToSubscribe[t_i].ResolvedQos = resolveQos(b, t)
+			}
+		}
+		for t_i, t := range e.TopicsToPublish {
+			if err := validateQos(t.Qos); err != nil {
+				v.entryError(e_i, fmt.Errorf("%v INDEX %d TOPIC %d", err, e_i, t_i), "topics-to-publish", t_i, "qos")
+			}
+			b, _ := cfg.Broker(t.ResolvedBroker)
+			e.TopicsToPublish[t_i].ResolvedQos = resolveQos(b, t)
+			e.TopicsToPublish[t_i].ResolvedRetain = resolveRetain(b, t)
+		}
+	}
+}
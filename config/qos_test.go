@@ -0,0 +1,84 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigQosAndRetain(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+  client-id: "dispatcher-kitchen"
+  qos: 1
+dispatcher-entries:
+  - name: "power"
+    source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "power"
+          - topic: "solar"
+            qos: 2
+    topics-to-publish:
+      - topic: "awtrix/custom/power"
+      - topic: "awtrix/custom/power-live"
+        qos: 0
+        retain: false
+`), nil
+	}
+
+	cfg, err := LoadConfig("config.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "dispatcher-kitchen", cfg.Mqtt.ClientId)
+
+	e := cfg.DispatcherEntries[0]
+	assert.Equal(t, byte(1), e.Source.MqttSource.TopicsToSubscribe[0].ResolvedQos)
+	assert.Equal(t, byte(2), e.Source.MqttSource.TopicsToSubscribe[1].ResolvedQos)
+	assert.Equal(t, byte(1), e.TopicsToPublish[0].ResolvedQos)
+	assert.True(t, e.TopicsToPublish[0].ResolvedRetain)
+	assert.Equal(t, byte(0), e.TopicsToPublish[1].ResolvedQos)
+	assert.False(t, e.TopicsToPublish[1].ResolvedRetain)
+}
+
+func TestLoadConfigQosAndRetainErrors(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+  qos: 3
+dispatcher-entries:
+  - name: "power"
+    source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "power"
+            retain: true
+    topics-to-publish:
+      - topic: "awtrix/custom/power"
+        qos: -1
+`), nil
+	}
+
+	cfg, err := LoadConfig("config.yaml")
+	assert.Nil(t, cfg)
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+
+	var problems []string
+	for _, p := range verr.Problems {
+		problems = append(problems, p.String())
+	}
+	assert.Equal(t, []string{
+		"config.yaml:3: ERROR: QOS MUST BE 0, 1 OR 2: 3",
+		"config.yaml:10: ERROR: RETAIN IS ONLY ALLOWED ON PUBLISH TOPICS INDEX 0",
+		"config.yaml:13: ERROR: QOS MUST BE 0, 1 OR 2: -1 INDEX 0 TOPIC 0",
+	}, problems)
+}
+
+func TestFingerprintFollowsResolvedQos(t *testing.T) {
+	e := Entry{Name: "power", TopicsToPublish: []MqttTopicDefinition{{Topic: "awtrix/custom/power"}}}
+	before := e.Fingerprint()
+	e.TopicsToPublish[0].ResolvedQos = 1
+	assert.NotEqual(t, before, e.Fingerprint())
+}
@@ -3,16 +3,22 @@ package config
 import (
 	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"sync"
 
 	"github.com/dop251/goja"
 )
 
 const (
-	errorEmptyScript   = "EMPTY SCRIPT"
-	errorRunningScript = "ERROR RUNNING SCRIPT"
-	errorAssertingFunc = "ERROR GETTING GET_COLOR FUNCTION FROM SCRIPT"
-	errorCallingFunc   = "ERROR CALLING GET_COLOR FUNCTION"
-	errorInvalidHex    = "INVALID HEX CODE"
+	errorEmptyScript        = "EMPTY SCRIPT"
+	errorRunningScript      = "ERROR RUNNING SCRIPT"
+	errorAssertingFunc      = "ERROR GETTING GET_COLOR FUNCTION FROM SCRIPT"
+	errorCallingFunc        = "ERROR CALLING GET_COLOR FUNCTION"
+	errorInvalidHex         = "INVALID HEX CODE"
+	errorAssertingValueFunc = "ERROR GETTING GET_VALUE FUNCTION FROM SCRIPT"
+	errorCallingValueFunc   = "ERROR CALLING GET_VALUE FUNCTION"
+	errorInvalidNumber      = "GET_VALUE DID NOT RETURN A NUMBER"
 )
 
 // isValidHexColor reports whether s is a 7-character hex color like "#RRGGBB".
@@ -20,7 +26,14 @@ func isValidHexColor(s string) bool {
 	return len(s) == 7 && s[0] == '#'
 }
 
-func createColorCallback(script string) (func(float64) (string, error), error) {
+// isScriptName reports whether a color-script is the name of one of the
+// color-scripts instead of a script, names contain no whitespace or brackets.
+func isScriptName(s string) bool {
+	return s != "" && !strings.ContainsAny(s, " \t\r\n(){}")
+}
+
+// loadScript runs script in a new goja runtime and returns the runtime.
+func loadScript(script string) (*goja.Runtime, error) {
 	if script == "" {
 		return nil, errors.New(errorEmptyScript)
 	}
@@ -30,12 +43,31 @@ func createColorCallback(script string) (func(float64) (string, error), error) {
 	if err != nil {
 		return nil, fmt.Errorf("%v: %v", errorRunningScript, err)
 	}
+	return vm, nil
+}
+
+func createColorCallback(script string) (func(float64) (string, error), error) {
+	return newColorCallback(script, 1.0)
+}
+
+// createTextColorCallback compiles a color-script for entries with text values,
+// get_color(v) is called with the published string.
+func createTextColorCallback(script string) (func(string) (string, error), error) {
+	return newColorCallback(script, "")
+}
+
+// newColorCallback compiles script and smoke-tests get_color with smoke.
+func newColorCallback[T float64 | string](script string, smoke T) (func(T) (string, error), error) {
+	vm, err := loadScript(script)
+	if err != nil {
+		return nil, err
+	}
 
 	get_color, ok := goja.AssertFunction(vm.Get("get_color"))
 	if !ok {
 		return nil, errors.New(errorAssertingFunc)
 	}
-	res, err := get_color(goja.Undefined(), vm.ToValue(1.0))
+	res, err := get_color(goja.Undefined(), vm.ToValue(smoke))
 	if err != nil {
 		return nil, fmt.Errorf("%v: %v", errorCallingFunc, err)
 	}
@@ -45,7 +77,12 @@ func createColorCallback(script string) (func(float64) (string, error), error) {
 		return nil, errors.New(errorInvalidHex)
 	}
 
-	return func(value float64) (string, error) {
+	// A goja runtime is not safe for concurrent use, the sources call the
+	// callback from their own goroutines.
+	var mu sync.Mutex
+	return func(value T) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
 		res, err := get_color(goja.Undefined(), vm.ToValue(value))
 		if err != nil {
 			return "", err
@@ -53,3 +90,45 @@ func createColorCallback(script string) (func(float64) (string, error), error) {
 		return res.String(), nil
 	}, nil
 }
+
+// createValueCallback compiles a value-script, which must define get_value(values)
+// returning a number. values maps every source id (mqtt topic or http url) to its
+// last value. The function is smoke-tested with 1.0 for every id in sourceIDs.
+func createValueCallback(script string, sourceIDs []string) (func(map[string]float64) (float64, error), error) {
+	vm, err := loadScript(script)
+	if err != nil {
+		return nil, err
+	}
+
+	get_value, ok := goja.AssertFunction(vm.Get("get_value"))
+	if !ok {
+		return nil, errors.New(errorAssertingValueFunc)
+	}
+
+	call := func(values map[string]float64) (float64, error) {
+		res, err := get_value(goja.Undefined(), vm.ToValue(values))
+		if err != nil {
+			return 0, err
+		}
+		v := res.ToFloat()
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return 0, fmt.Errorf("%v: '%v'", errorInvalidNumber, res)
+		}
+		return v, nil
+	}
+
+	smoke := make(map[string]float64, len(sourceIDs))
+	for _, id := range sourceIDs {
+		smoke[id] = 1.0
+	}
+	if _, err := call(smoke); err != nil {
+		return nil, fmt.Errorf("%v: %v", errorCallingValueFunc, err)
+	}
+
+	var mu sync.Mutex
+	return func(values map[string]float64) (float64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return call(values)
+	}, nil
+}
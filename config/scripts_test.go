@@ -105,3 +105,73 @@ func TestCreateColorCallbackErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateValueCallback(t *testing.T) {
+	script := `
+function get_value(values) {
+  return values["grid"] + values["solar"] - values["battery"];
+}
+`
+	cb, err := createValueCallback(script, []string{"grid", "solar", "battery"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := cb(map[string]float64{"grid": 400, "solar": 300, "battery": 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != 600 {
+		t.Errorf("got %f, want 600", got)
+	}
+
+	// A source that has not reported yet is undefined in the script.
+	if _, err := cb(map[string]float64{"grid": 400}); err == nil || !strings.Contains(err.Error(), errorInvalidNumber) {
+		t.Errorf("expected error to contain %q, got %v", errorInvalidNumber, err)
+	}
+}
+
+func TestCreateValueCallbackErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{
+			name:    "Empty script",
+			script:  "",
+			wantErr: errorEmptyScript,
+		},
+		{
+			name:    "Syntax error",
+			script:  `function get_value(values) { return 1;`,
+			wantErr: errorRunningScript,
+		},
+		{
+			name:    "Missing get_value function",
+			script:  `function get_color(v) { return "#FFFFFF"; }`,
+			wantErr: errorAssertingValueFunc,
+		},
+		{
+			name:    "Throws in script",
+			script:  `function get_value(values) { throw "BROKEN"; }`,
+			wantErr: errorCallingValueFunc,
+		},
+		{
+			name:    "Returns no number",
+			script:  `function get_value(values) { return "abc"; }`,
+			wantErr: errorInvalidNumber,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := createValueCallback(tc.script, []string{"a"})
+			if err == nil {
+				t.Errorf("expected error, got none")
+			} else if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error to contain %q, got %q", tc.wantErr, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	osLookupEnv = func(key string) (string, bool) {
+		return os.LookupEnv(key)
+	}
+)
+
+// secretFilePrefix marks a value that is read from a file, e.g. a docker secret
+// file:/run/secrets/mqtt_password
+const secretFilePrefix = "file:"
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolveReference expands the ${ENV_VAR} references in s, afterwards a value
+// starting with file: is replaced by the content of the file without trailing
+// newlines. The substituted texts are returned as well.
+func resolveReference(s string) (string, []string, error) {
+	var substituted []string
+	var err error
+	s = envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		v, ok := osLookupEnv(name)
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("ERROR: ENVIRONMENT VARIABLE '%s' IS NOT SET", name)
+			}
+			return ref
+		}
+		substituted = append(substituted, v)
+		return v
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	if path, ok := strings.CutPrefix(s, secretFilePrefix); ok {
+		data, err := osReadFile(path)
+		if err != nil {
+			return "", nil, fmt.Errorf("ERROR READING SECRET FILE '%s': %v", path, err)
+		}
+		s = strings.TrimRight(string(data), "\r\n")
+		substituted = []string{s}
+	}
+	return s, substituted, nil
+}
+
+// resolveReferences resolves the references in the mqtt section, the brokers,
+// the http urls and the tibber api keys. The values of password and
+// tibber-api-key and the texts substituted into urls are collected as secrets.
+func resolveReferences(cfg *RootConfig, v *validator, mqttFile string) {
+	resolveMqttReferences(cfg, &cfg.Mqtt, func(key string, err error) {
+		v.add(mqttFile, false, err, "mqtt", key)
+	})
+	for b_i := range cfg.Brokers {
+		resolveMqttReferences(cfg, &cfg.Brokers[b_i], func(key string, err error) {
+			v.brokerError(b_i, err, key)
+		})
+	}
+
+	resolve := func(field *string, name string) ([]string, error) {
+		v, substituted, err := resolveReference(*field)
+		if err != nil {
+			return nil, fmt.Errorf("%v IN %s", err, name)
+		}
+		*field = v
+		return substituted, nil
+	}
+	for e_i := range cfg.DispatcherEntries {
+		src := &cfg.DispatcherEntries[e_i].Source
+		if src.HttpSource != nil {
+			for u_i := range src.HttpSource.Urls {
+				substituted, err := resolve(&src.HttpSource.Urls[u_i].Url, "HTTP URL")
+				if err != nil {
+					v.entryError(e_i, fmt.Errorf("%v INDEX %d", err, e_i), "source", "http", "urls", u_i, "url")
+					continue
+				}
+				cfg.secrets = append(cfg.secrets, substituted...)
+			}
+		}
+		if src.TibberApiSource != nil {
+			if _, err := resolve(&src.TibberApiSource.TibberApiKey, "TIBBER-API-KEY"); err != nil {
+				v.entryError(e_i, fmt.Errorf("%v INDEX %d", err, e_i), "source", "tibber-api", "tibber-api-key")
+				continue
+			}
+			cfg.secrets = append(cfg.secrets, src.TibberApiSource.TibberApiKey)
+		}
+	}
+}
+
+// resolveMqttReferences resolves the references of the broker m, problems are
+// reported with the key.
+func resolveMqttReferences(cfg *RootConfig, m *MqttConfig, report func(key string, err error)) {
+	for _, f := range []struct {
+		field  *string
+		key    string
+		secret bool
+	}{
+		{&m.Broker, "broker", false},
+		{&m.ClientId, "client-id", false},
+		{&m.Username, "username", false},
+		{&m.Password, "password", true},
+		{&m.CaFile, "ca-file", false},
+		{&m.ClientCertFile, "client-cert-file", false},
+		{&m.ClientKeyFile, "client-key-file", false},
+	} {
+		v, _, err := resolveReference(*f.field)
+		if err != nil {
+			report(f.key, fmt.Errorf("%v IN MQTT %s", err, strings.ToUpper(f.key)))
+			continue
+		}
+		*f.field = v
+		if f.secret {
+			cfg.secrets = append(cfg.secrets, v)
+		}
+	}
+}
+
+// Secrets returns the resolved secrets of the config, to be redacted from logs
+// and printed configs.
+func (c *RootConfig) Secrets() []string {
+	var secrets []string
+	for _, s := range c.secrets {
+		if s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+	return secrets
+}
+
+// Redacted returns a copy of the config for printing with the passwords and
+// tibber api keys masked by key, so short secrets the log redaction skips are
+// hidden as well.
+func (c *RootConfig) Redacted() *RootConfig {
+	const mask = "***"
+	redact := func(m *MqttConfig) {
+		if m.Password != "" {
+			m.Password = mask
+		}
+	}
+
+	r := *c
+	redact(&r.Mqtt)
+	r.Brokers = slices.Clone(c.Brokers)
+	for i := range r.Brokers {
+		redact(&r.Brokers[i])
+	}
+	r.DispatcherEntries = slices.Clone(c.DispatcherEntries)
+	for i, e := range r.DispatcherEntries {
+		if e.Source.TibberApiSource != nil && e.Source.TibberApiSource.TibberApiKey != "" {
+			src := *e.Source.TibberApiSource
+			src.TibberApiKey = mask
+			r.DispatcherEntries[i].Source.TibberApiSource = &src
+		}
+	}
+	return &r
+}
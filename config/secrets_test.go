@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"go-mqtt-dispatcher/logging"
+	"log/slog"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigReferences(t *testing.T) {
+	env := map[string]string{"MQTT_USER": "dispatcher", "TOKEN": "t0ken"}
+	lookupEnv := osLookupEnv
+	defer func() { osLookupEnv = lookupEnv }()
+	osLookupEnv = func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	osReadFile = func(path string) ([]byte, error) {
+		switch path {
+		case "/run/secrets/mqtt_password":
+			return []byte("s3cret\n"), nil
+		}
+		return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+  username: "${MQTT_USER}"
+  password: "file:/run/secrets/mqtt_password"
+dispatcher-entries:
+  - source:
+      http:
+        interval_sec: 10
+        urls:
+          - url: "http://example.com/api?token=${TOKEN}"
+  - source:
+      tibber-api:
+        tibber-api-key: "plain-key"
+        interval_sec: 10
+`), nil
+	}
+
+	cfg, err := LoadConfig("dummy_path")
+	require.NoError(t, err)
+	assert.Equal(t, "dispatcher", cfg.Mqtt.Username)
+	assert.Equal(t, "s3cret", cfg.Mqtt.Password)
+	assert.Equal(t, "http://example.com/api?token=t0ken", cfg.DispatcherEntries[0].Source.HttpSource.Urls[0].Url)
+	assert.Equal(t, []string{"s3cret", "t0ken", "plain-key"}, cfg.Secrets())
+
+	// The resolved token is redacted from errors and urls logged as values
+	var buf bytes.Buffer
+	r := logging.NewRedactor()
+	r.SetSecrets(cfg.Secrets())
+	u, err := url.Parse(cfg.DispatcherEntries[0].Source.HttpSource.Urls[0].Url)
+	require.NoError(t, err)
+	logging.New(&buf, logging.FormatJson, slog.LevelInfo, r).Error("Error polling",
+		"error", &url.Error{Op: "Get", URL: u.String(), Err: errors.New("timeout")}, "url", u)
+	assert.NotContains(t, buf.String(), "t0ken")
+	assert.Contains(t, buf.String(), `"url":"http://example.com/api?token=***"`)
+
+	// Redacted masks by key, the loaded config keeps the secrets
+	redacted := cfg.Redacted()
+	assert.Equal(t, "***", redacted.Mqtt.Password)
+	assert.Equal(t, "***", redacted.DispatcherEntries[1].Source.TibberApiSource.TibberApiKey)
+	assert.Equal(t, "s3cret", cfg.Mqtt.Password)
+	assert.Equal(t, "plain-key", cfg.DispatcherEntries[1].Source.TibberApiSource.TibberApiKey)
+
+	tests := []struct {
+		name                 string
+		config               string
+		expectedErrorMessage string
+	}{
+		{
+			name: "MissingVariable",
+			config: `
+mqtt:
+  broker: "tcp://localhost:1883"
+  password: "${MQTT_PASSWORD}"
+`,
+			expectedErrorMessage: "dummy_path:4: ERROR: ENVIRONMENT VARIABLE 'MQTT_PASSWORD' IS NOT SET IN MQTT PASSWORD",
+		},
+		{
+			name: "MissingVariableInUrl",
+			config: `
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - source:
+      http:
+        interval_sec: 10
+        urls:
+          - url: "http://example.com/api?token=${API_TOKEN}"
+`,
+			expectedErrorMessage: "dummy_path:9: ERROR: ENVIRONMENT VARIABLE 'API_TOKEN' IS NOT SET IN HTTP URL INDEX 0",
+		},
+		{
+			name: "UnreadableSecretFile",
+			config: `
+mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - source:
+      tibber-api:
+        tibber-api-key: "file:/run/secrets/tibber"
+`,
+			expectedErrorMessage: "dummy_path:7: ERROR READING SECRET FILE '/run/secrets/tibber': permission denied IN TIBBER-API-KEY INDEX 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			osReadFile = func(path string) ([]byte, error) {
+				if path == "/run/secrets/tibber" {
+					return nil, errors.New("permission denied")
+				}
+				return []byte(tt.config), nil
+			}
+			cfg, err := LoadConfig("dummy_path")
+			assert.Nil(t, cfg)
+			assert.ErrorContains(t, err, tt.expectedErrorMessage)
+		})
+	}
+}
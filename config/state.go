@@ -0,0 +1,55 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultStateInterval = 1 * time.Minute
+	defaultStateMaxAge   = 1 * time.Hour
+)
+
+// StateConfig enables the state file. The source values and fallback tracks of
+// all entries are saved to File every Interval and on shutdown, and restored on
+// startup. Values older than MaxAge are discarded when restoring.
+type StateConfig struct {
+	File     string `yaml:"file"`
+	Interval string `yaml:"interval,omitempty"`
+	MaxAge   string `yaml:"max-age,omitempty"`
+
+	// Late binding
+	SaveInterval time.Duration `yaml:"-"`
+	MaxAgeParsed time.Duration `yaml:"-"`
+}
+
+// validateState checks the state section and parses its durations.
+func validateState(s *StateConfig) error {
+	if s.File == "" {
+		return errors.New("ERROR: STATE FILE IS REQUIRED")
+	}
+
+	var err error
+	if s.SaveInterval, err = parseStateDuration(s.Interval, defaultStateInterval); err != nil {
+		return fmt.Errorf("ERROR: INVALID STATE INTERVAL '%s'", s.Interval)
+	}
+	if s.MaxAgeParsed, err = parseStateDuration(s.MaxAge, defaultStateMaxAge); err != nil {
+		return fmt.Errorf("ERROR: INVALID STATE MAX-AGE '%s'", s.MaxAge)
+	}
+	return nil
+}
+
+func parseStateDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("duration must be positive")
+	}
+	return d, nil
+}
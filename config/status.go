@@ -0,0 +1,33 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// validateStatus checks the status topic and parses the heartbeat interval of
+// the mqtt section.
+func validateStatus(m *MqttConfig) error {
+	if strings.ContainsAny(m.StatusTopic, "+#") {
+		return fmt.Errorf("ERROR: STATUS-TOPIC MUST NOT CONTAIN WILDCARDS: '%s'", m.StatusTopic)
+	}
+	if m.HeartbeatInterval == "" {
+		return nil
+	}
+	if m.StatusTopic == "" {
+		return errors.New("ERROR: HEARTBEAT-INTERVAL REQUIRES A STATUS-TOPIC")
+	}
+	d, err := time.ParseDuration(m.HeartbeatInterval)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("ERROR: INVALID HEARTBEAT-INTERVAL '%s'", m.HeartbeatInterval)
+	}
+	m.Heartbeat = d
+	return nil
+}
+
+// HeartbeatTopic returns the topic of the heartbeat messages, below the status topic.
+func (m MqttConfig) HeartbeatTopic() string {
+	return m.StatusTopic + "/heartbeat"
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigStatusTopic(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+  status-topic: "go-mqtt-dispatcher/status"
+  heartbeat-interval: "30s"
+`), nil
+	}
+
+	cfg, err := LoadConfig("config.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, cfg.Mqtt.Heartbeat)
+	assert.Equal(t, "go-mqtt-dispatcher/status/heartbeat", cfg.Mqtt.HeartbeatTopic())
+}
+
+func TestValidateStatus(t *testing.T) {
+	tests := []struct {
+		mqtt MqttConfig
+		err  string
+	}{
+		{MqttConfig{StatusTopic: "dispatcher/status"}, ""},
+		{MqttConfig{StatusTopic: "dispatcher/+"}, "ERROR: STATUS-TOPIC MUST NOT CONTAIN WILDCARDS: 'dispatcher/+'"},
+		{MqttConfig{HeartbeatInterval: "1m"}, "ERROR: HEARTBEAT-INTERVAL REQUIRES A STATUS-TOPIC"},
+		{MqttConfig{StatusTopic: "dispatcher/status", HeartbeatInterval: "-1m"}, "ERROR: INVALID HEARTBEAT-INTERVAL '-1m'"},
+		{MqttConfig{StatusTopic: "dispatcher/status", HeartbeatInterval: "often"}, "ERROR: INVALID HEARTBEAT-INTERVAL 'often'"},
+	}
+	for _, tt := range tests {
+		err := validateStatus(&tt.mqtt)
+		if tt.err == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, tt.err)
+		}
+	}
+}
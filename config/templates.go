@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// templatePlaceholder matches a {{variable}} in the string values of a template.
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_-]*)\s*\}\}`)
+
+// expandTemplate returns the entry of the template tpl with its placeholders
+// replaced by the variables of e. The keys set on e replace the keys of the
+// template. All placeholders must have a variable and all variables must be
+// used.
+func expandTemplate(tpl yaml.MapSlice, e Entry) (Entry, error) {
+	used := map[string]bool{}
+	var err error
+	expanded := expandPlaceholders(tpl, e.Variables, used, &err)
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, name := range sortedKeys(e.Variables) {
+		if !used[name] {
+			return Entry{}, fmt.Errorf("ERROR: TEMPLATE '%s' HAS NO PLACEHOLDER FOR VARIABLE '%s'", e.UseTemplate, name)
+		}
+	}
+
+	overrides := e
+	overrides.UseTemplate, overrides.Variables = "", nil
+	var overrideKeys yaml.MapSlice
+	data, err := yaml.Marshal(overrides)
+	if err != nil {
+		return Entry{}, err
+	}
+	if err := yaml.Unmarshal(data, &overrideKeys); err != nil {
+		return Entry{}, err
+	}
+
+	merged := expanded.(yaml.MapSlice)
+	for _, o := range overrideKeys {
+		if o.Value == "" {
+			// name is marshalled without omitempty
+			continue
+		}
+		replaced := false
+		for i := range merged {
+			if merged[i].Key == o.Key {
+				merged[i].Value = o.Value
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, o)
+		}
+	}
+
+	data, err = yaml.Marshal(merged)
+	if err != nil {
+		return Entry{}, err
+	}
+	var result Entry
+	if err := yaml.UnmarshalStrict(data, &result); err != nil {
+		return Entry{}, fmt.Errorf("ERROR: INVALID TEMPLATE '%s': %v", e.UseTemplate, err)
+	}
+	if result.UseTemplate != "" {
+		return Entry{}, fmt.Errorf("ERROR: TEMPLATE '%s' MUST NOT USE A TEMPLATE", e.UseTemplate)
+	}
+	return result, nil
+}
+
+// expandPlaceholders replaces the placeholders in the string values of v. A
+// value that is a single placeholder takes the type of the variable, e.g. a
+// number for interval_sec. The first missing variable is stored in err.
+func expandPlaceholders(v interface{}, vars map[string]string, used map[string]bool, err *error) interface{} {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		result := make(yaml.MapSlice, len(v))
+		for i, item := range v {
+			result[i] = yaml.MapItem{Key: item.Key, Value: expandPlaceholders(item.Value, vars, used, err)}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = expandPlaceholders(item, vars, used, err)
+		}
+		return result
+	case string:
+		lookup := func(ref string) (string, bool) {
+			name := templatePlaceholder.FindStringSubmatch(ref)[1]
+			value, ok := vars[name]
+			if !ok && *err == nil {
+				*err = fmt.Errorf("ERROR: TEMPLATE VARIABLE '%s' IS NOT SET", name)
+			}
+			used[name] = true
+			return value, ok
+		}
+		if m := templatePlaceholder.FindString(v); m != "" && m == v {
+			value, ok := lookup(m)
+			if !ok {
+				return v
+			}
+			// Only plain numbers and bools, e.g. a device id 0123 stays a string
+			var typed interface{}
+			if yaml.Unmarshal([]byte(value), &typed) == nil {
+				switch typed.(type) {
+				case int, float64, bool:
+					if fmt.Sprint(typed) == value {
+						return typed
+					}
+				}
+			}
+			return value
+		}
+		return templatePlaceholder.ReplaceAllStringFunc(v, func(ref string) string {
+			if value, ok := lookup(ref); ok {
+				return value
+			}
+			return ref
+		})
+	}
+	return v
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigTemplates(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`
+mqtt:
+  broker: "tcp://localhost:1883"
+templates:
+  shelly-plug:
+    name: "{{room}} power"
+    icon: "redplug"
+    topics-to-publish:
+      - topic: "awtrix/custom/{{room}}"
+        transform:
+          outputFormat: "%.0f W"
+    source:
+      http:
+        interval_sec: "{{interval}}"
+        urls:
+          - url: "http://{{device}}/rpc/Switch.GetStatus?id=0"
+            transform:
+              jsonPath: "$.apower"
+dispatcher-entries:
+  - use-template: "shelly-plug"
+    variables:
+      room: "kitchen"
+      device: "0123"
+      interval: 10
+  - use-template: "shelly-plug"
+    icon: "plug"
+    disabled: true
+    variables:
+      room: "office"
+      device: "shellyplug-office"
+      interval: 30
+`), nil
+	}
+
+	cfg, err := LoadConfig("dummy_path")
+	require.NoError(t, err)
+	require.Len(t, cfg.DispatcherEntries, 2)
+
+	kitchen := cfg.DispatcherEntries[0]
+	assert.Equal(t, "kitchen power", kitchen.Name)
+	assert.Equal(t, "redplug", kitchen.Icon)
+	assert.False(t, kitchen.Disabled)
+	assert.Equal(t, "awtrix/custom/kitchen", kitchen.TopicsToPublish[0].Topic)
+	assert.Equal(t, "%.0f W", kitchen.TopicsToPublish[0].Transform.OutputFormat)
+	assert.Equal(t, 10, kitchen.Source.HttpSource.IntervalSec)
+	assert.Equal(t, "http://0123/rpc/Switch.GetStatus?id=0", kitchen.Source.HttpSource.Urls[0].Url)
+	assert.Empty(t, kitchen.UseTemplate)
+	assert.Nil(t, kitchen.Variables)
+
+	office := cfg.DispatcherEntries[1]
+	assert.Equal(t, "office power", office.Name)
+	assert.Equal(t, "plug", office.Icon)
+	assert.True(t, office.Disabled)
+	assert.Equal(t, 30, office.Source.HttpSource.IntervalSec)
+}
+
+func TestLoadConfigTemplateErrors(t *testing.T) {
+	template := `
+mqtt:
+  broker: "tcp://localhost:1883"
+templates:
+  plug:
+    name: "{{room}} power"
+    source:
+      http:
+        interval_sec: "{{interval}}"
+        urls:
+          - url: "http://plug/"
+dispatcher-entries:
+`
+	tests := []struct {
+		name                 string
+		entries              string
+		expectedErrorMessage string
+	}{
+		{
+			name: "UnknownTemplate",
+			entries: `
+  - use-template: "socket"
+`,
+			expectedErrorMessage: "ERROR: UNKNOWN TEMPLATE 'socket' INDEX 0",
+		},
+		{
+			name: "MissingVariable",
+			entries: `
+  - use-template: "plug"
+    variables:
+      room: "kitchen"
+`,
+			expectedErrorMessage: "ERROR: TEMPLATE VARIABLE 'interval' IS NOT SET INDEX 0",
+		},
+		{
+			name: "UnusedVariable",
+			entries: `
+  - use-template: "plug"
+    variables:
+      room: "kitchen"
+      interval: 10
+      devcie: "plug-1"
+`,
+			expectedErrorMessage: "ERROR: TEMPLATE 'plug' HAS NO PLACEHOLDER FOR VARIABLE 'devcie' INDEX 0",
+		},
+		{
+			name: "VariablesWithoutTemplate",
+			entries: `
+  - name: "kitchen"
+    variables:
+      room: "kitchen"
+`,
+			expectedErrorMessage: "ERROR: VARIABLES REQUIRE USE-TEMPLATE INDEX 0",
+		},
+		{
+			name: "DuplicateExpandedName",
+			entries: `
+  - use-template: "plug"
+    variables: {room: "kitchen", interval: 10}
+  - name: "kitchen power"
+`,
+			expectedErrorMessage: "dummy_path:16: ERROR: DUPLICATE ENTRY NAME 'kitchen power', FIRST DEFINED AT dummy_path:14 INDEX 1",
+		},
+		{
+			name: "InvalidValue",
+			entries: `
+  - use-template: "plug"
+    variables: {room: "kitchen", interval: "ten"}
+`,
+			expectedErrorMessage: "ERROR: INVALID TEMPLATE 'plug'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			osReadFile = func(path string) ([]byte, error) {
+				return []byte(template + tt.entries), nil
+			}
+			cfg, err := LoadConfig("dummy_path")
+			assert.Nil(t, cfg)
+			assert.ErrorContains(t, err, tt.expectedErrorMessage)
+		})
+	}
+}
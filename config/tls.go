@@ -0,0 +1,94 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// brokerSchemes maps the supported broker url schemes to whether they use TLS.
+var brokerSchemes = map[string]bool{
+	"tcp":   false,
+	"mqtt":  false,
+	"ws":    false,
+	"ssl":   true,
+	"tls":   true,
+	"mqtts": true,
+	"wss":   true,
+}
+
+// UsesTLS reports whether the broker scheme requires a TLS connection.
+func (m MqttConfig) UsesTLS() bool {
+	return m.BrokerAsUri != nil && brokerSchemes[m.BrokerAsUri.Scheme]
+}
+
+// hasTLSOptions reports whether any of the tls related keys are set.
+func (m MqttConfig) hasTLSOptions() bool {
+	return m.CaFile != "" || m.ClientCertFile != "" || m.ClientKeyFile != "" || m.InsecureSkipVerify
+}
+
+// validateBroker checks the broker scheme and builds the tls config for secure schemes.
+func validateBroker(m *MqttConfig) error {
+	scheme := m.BrokerAsUri.Scheme
+	if _, ok := brokerSchemes[scheme]; !ok {
+		return fmt.Errorf("ERROR: UNSUPPORTED BROKER SCHEME: '%s'", scheme)
+	}
+	if m.BrokerAsUri.Host == "" {
+		return fmt.Errorf("ERROR: MISSING BROKER HOST: '%s'", m.Broker)
+	}
+
+	if !m.UsesTLS() {
+		if m.hasTLSOptions() {
+			return fmt.Errorf("ERROR: TLS OPTIONS REQUIRE A TLS BROKER SCHEME (mqtts, ssl, tls, wss), GOT: '%s'", scheme)
+		}
+		return nil
+	}
+
+	tlsConfig, err := createTLSConfig(*m)
+	if err != nil {
+		return err
+	}
+	m.TLSConfig = tlsConfig
+	return nil
+}
+
+// createTLSConfig builds the tls config from the ca bundle and client certificate files.
+func createTLSConfig(m MqttConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: m.InsecureSkipVerify,
+	}
+
+	if m.CaFile != "" {
+		pem, err := osReadFile(m.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR READING CA FILE '%s': %v", m.CaFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ERROR: NO CERTIFICATES FOUND IN CA FILE '%s'", m.CaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (m.ClientCertFile == "") != (m.ClientKeyFile == "") {
+		return nil, fmt.Errorf("ERROR: CLIENT-CERT-FILE AND CLIENT-KEY-FILE MUST BE SET TOGETHER")
+	}
+	if m.ClientCertFile != "" {
+		certPem, err := osReadFile(m.ClientCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR READING CLIENT CERT FILE '%s': %v", m.ClientCertFile, err)
+		}
+		keyPem, err := osReadFile(m.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR READING CLIENT KEY FILE '%s': %v", m.ClientKeyFile, err)
+		}
+		cert, err := tls.X509KeyPair(certPem, keyPem)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR LOADING CLIENT CERTIFICATE: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -3,50 +3,132 @@
 package config
 
 import (
+	"crypto/tls"
+	"fmt"
 	"net/url"
 	"time"
+
+	"gopkg.in/yaml.v2"
 )
 
 type RootConfig struct {
-	Mqtt              MqttConfig `yaml:"mqtt"`
-	DispatcherEntries []Entry    `yaml:"dispatcher-entries"`
+	Include []string   `yaml:"include,omitempty"`
+	Mqtt    MqttConfig `yaml:"mqtt"`
+	// Brokers are further brokers, selected by name with source-broker and
+	// publish-broker of the entries, the mqtt section is the default broker
+	Brokers      []MqttConfig      `yaml:"brokers,omitempty"`
+	Logging      *LoggingConfig    `yaml:"logging,omitempty"`
+	State        *StateConfig      `yaml:"state,omitempty"`
+	HttpServer   *HttpServerConfig `yaml:"http-server,omitempty"`
+	ColorScripts map[string]string `yaml:"color-scripts,omitempty"`
+	// Templates are entries with {{variable}} placeholders, expanded into the
+	// entries that use them while loading
+	Templates         map[string]yaml.MapSlice `yaml:"templates,omitempty"`
+	DispatcherEntries []Entry                  `yaml:"dispatcher-entries"`
+
+	// Late binding, see Secrets, Files and Warnings
+	secrets  []string
+	files    []string
+	warnings []Problem
 }
 
 type MqttConfig struct {
-	Broker   string `yaml:"broker"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Name               string `yaml:"name,omitempty"` // brokers only
+	Broker             string `yaml:"broker"`
+	Username           string `yaml:"username"`
+	Password           string `yaml:"password"`
+	CaFile             string `yaml:"ca-file,omitempty"`
+	ClientCertFile     string `yaml:"client-cert-file,omitempty"`
+	ClientKeyFile      string `yaml:"client-key-file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify,omitempty"`
+	ClientId           string `yaml:"client-id,omitempty"`
+	// Qos and Retain are the defaults of the topics
+	Qos    *int  `yaml:"qos,omitempty"`
+	Retain *bool `yaml:"retain,omitempty"`
+	// StatusTopic receives "online" and, as last will, "offline"
+	StatusTopic       string `yaml:"status-topic,omitempty"`
+	HeartbeatInterval string `yaml:"heartbeat-interval,omitempty"`
+	// ProtocolVersion is 4 for MQTT 3.1.1 (default) or 5 for MQTT 5
+	ProtocolVersion int `yaml:"protocol-version,omitempty"`
 
 	// Late binding
-	BrokerAsUri *url.URL
+	BrokerAsUri *url.URL      `yaml:"-"`
+	TLSConfig   *tls.Config   `yaml:"-"` // nil for plain tcp:// and ws:// brokers
+	Heartbeat   time.Duration `yaml:"-"` // 0 without heartbeat
 }
 
 type Entry struct {
 	Name            string                `yaml:"name"`
 	Disabled        bool                  `yaml:"disabled,omitempty"`
+	Debug           bool                  `yaml:"debug,omitempty"`
 	TopicsToPublish []MqttTopicDefinition `yaml:"topics-to-publish,omitempty"`
 	Icon            string                `yaml:"icon,omitempty"`
 	ColorScript     string                `yaml:"color-script,omitempty"`
+	ValueScript     string                `yaml:"value-script,omitempty"`
 	Operation       string                `yaml:"operation,omitempty"`
+	Awtrix          *AwtrixDefinition     `yaml:"awtrix,omitempty"`
 	Source          EntrySource           `yaml:"source,omitempty"`
 	Fallback        *FallbackDefinition   `yaml:"fallback,omitempty"`
 
-	// Late binding
-	ColorScriptCallback func(float64) (string, error)
-	FallbackAfter       time.Duration
+	SourceMaxAge     string `yaml:"source-max-age,omitempty"`
+	SourceExpiryMode string `yaml:"source-expiry,omitempty"`
+
+	// Names of the brokers, "" is the mqtt section
+	SourceBroker  string `yaml:"source-broker,omitempty"`
+	PublishBroker string `yaml:"publish-broker,omitempty"`
+
+	// Expanded at load time, see templates
+	UseTemplate string            `yaml:"use-template,omitempty"`
+	Variables   map[string]string `yaml:"variables,omitempty"`
+
+	// Late binding, excluded from yaml so Fingerprint can marshal the entry
+	ColorScriptCallback func(float64) (string, error)             `yaml:"-"`
+	TextColorCallback   func(string) (string, error)              `yaml:"-"`
+	ValueScriptCallback func(map[string]float64) (float64, error) `yaml:"-"`
+	FallbackAfter       time.Duration                             `yaml:"-"`
+	SourceMaxAgeParsed  time.Duration                             `yaml:"-"`
 }
 
 type MqttTopicDefinition struct {
-	Topic     string              `yaml:"topic"`
-	Transform TransformDefinition `yaml:"transform"`
-	Filter    *FilterDefinition   `yaml:"filter,omitempty"`
+	Topic  string `yaml:"topic"`
+	Qos    *int   `yaml:"qos,omitempty"`
+	Retain *bool  `yaml:"retain,omitempty"` // publish topics only
+	// ShareGroup makes a subscription shared, NoLocal drops the messages of the
+	// dispatcher itself (MQTT 5), subscribe topics only
+	ShareGroup string `yaml:"share-group,omitempty"`
+	NoLocal    bool   `yaml:"no-local,omitempty"`
+	// MQTT 5 properties of the published messages, publish topics only
+	MessageExpiry  string            `yaml:"message-expiry,omitempty"`
+	ContentType    string            `yaml:"content-type,omitempty"`
+	UserProperties map[string]string `yaml:"user-properties,omitempty"`
+	// ResponseTopic and CorrelationData let the receiver reply to a message
+	ResponseTopic   string              `yaml:"response-topic,omitempty"`
+	CorrelationData string              `yaml:"correlation-data,omitempty"`
+	Transform       TransformDefinition `yaml:"transform"`
+	Filter          *FilterDefinition   `yaml:"filter,omitempty"`
+	Awtrix          *AwtrixDefinition   `yaml:"awtrix,omitempty"`
+
+	// Late binding, the awtrix options of the entry merged with the ones of this topic
+	ResolvedAwtrix *AwtrixDefinition `yaml:"-"`
+	// Late binding, qos and retain of this topic or else the defaults of the broker
+	ResolvedQos    byte `yaml:"-"`
+	ResolvedRetain bool `yaml:"-"`
+	// ResolvedBroker is the name of the broker of the topic, "" for the mqtt section
+	ResolvedBroker string `yaml:"-"`
+	// ResolvedMessageExpiry is the parsed message-expiry, 0 without expiry
+	ResolvedMessageExpiry time.Duration `yaml:"-"`
 }
 
 type TransformDefinition struct {
-	JsonPath            string `yaml:"jsonPath"`
-	Invert              bool   `yaml:"invert,omitempty"`
-	OutputFormat        string `yaml:"outputFormat,omitempty"`
-	OutputAsTibberGraph bool   `yaml:"output-as-tibber-graph,omitempty"`
+	JsonPath            string            `yaml:"jsonPath"`
+	Invert              bool              `yaml:"invert,omitempty"`
+	ValueType           string            `yaml:"value-type,omitempty"`
+	Mapping             map[string]string `yaml:"mapping,omitempty"`
+	OutputFormat        string            `yaml:"outputFormat,omitempty"`
+	OutputAsTibberGraph bool              `yaml:"output-as-tibber-graph,omitempty"`
+	OutputFragments     []TextFragment    `yaml:"output-fragments,omitempty"`
+
+	OutputAsHistoryChart *HistoryChartDefinition `yaml:"output-as-history-chart,omitempty"`
 }
 
 type FilterDefinition struct {
@@ -90,6 +172,8 @@ type TibberApiSource struct {
 type TransformTarget interface {
 	GetOutputFormat() string
 	GetOutputAsTibberGraph() bool
+	GetOutputFragments() []TextFragment
+	GetOutputAsHistoryChart() *HistoryChartDefinition
 }
 
 func (m MqttTopicDefinition) GetOutputFormat() string {
@@ -121,6 +205,12 @@ type TransformSource interface {
 	GetInvert() bool
 }
 
+// ValueTypeSource is implemented by the source definitions that support text values.
+type ValueTypeSource interface {
+	GetValueType() valueType
+	GetMapping() map[string]string
+}
+
 type Transformers interface {
 	TransformSource
 	TransformTarget
@@ -150,11 +240,54 @@ func (t TibberApiSource) GetInvert() bool {
 	return t.Transform.Invert
 }
 
+func (m MqttTopicDefinition) GetValueType() valueType {
+	return valueType(m.Transform.ValueType)
+}
+
+func (h HttpUrlDefinition) GetValueType() valueType {
+	return valueType(h.Transform.ValueType)
+}
+
+func (t TibberApiSource) GetValueType() valueType {
+	return valueType(t.Transform.ValueType)
+}
+
+func (m MqttTopicDefinition) GetMapping() map[string]string {
+	return m.Transform.Mapping
+}
+
+func (h HttpUrlDefinition) GetMapping() map[string]string {
+	return h.Transform.Mapping
+}
+
+func (t TibberApiSource) GetMapping() map[string]string {
+	return t.Transform.Mapping
+}
+
+type valueType string
+
+const (
+	ValueTypeNumber valueType = "number" // default
+	ValueTypeString valueType = "string"
+	ValueTypeBool   valueType = "bool"
+)
+
+// IsText reports whether values of this type are published as text instead of numbers.
+func (v valueType) IsText() bool {
+	return v == ValueTypeString || v == ValueTypeBool
+}
+
 type operator string
 
 const (
-	OperatorNone operator = ""
-	OperatorSum  operator = "sum"
+	OperatorNone    operator = ""
+	OperatorSum     operator = "sum"
+	OperatorAvg     operator = "avg"
+	OperatorMin     operator = "min"
+	OperatorMax     operator = "max"
+	OperatorCount   operator = "count"
+	OperatorProduct operator = "product"
+	OperatorDiff    operator = "diff" // first source minus the rest
 )
 
 type fallbackMode string
@@ -180,7 +313,14 @@ func (e Entry) FallbackMode() fallbackMode {
 	return fallbackMode(e.Fallback.Mode)
 }
 
+// MustAccumulate reports whether the values of the sources are kept in the
+// accumulation state, which is the case for several sources or a value-script.
+// The topics matched by a wildcard are several sources if an operation is set,
+// without one the value of each matched topic is published as it is.
 func (e Entry) MustAccumulate() (bool, operator) {
+	if e.ValueScript != "" {
+		return true, operator(e.Operation)
+	}
 	if e.Source.HttpSource != nil {
 		if len(e.Source.HttpSource.Urls) > 1 {
 			return true, operator(e.Operation)
@@ -190,10 +330,97 @@ func (e Entry) MustAccumulate() (bool, operator) {
 		if len(e.Source.MqttSource.TopicsToSubscribe) > 1 {
 			return true, operator(e.Operation)
 		}
+		for _, t := range e.Source.MqttSource.TopicsToSubscribe {
+			if IsWildcard(t.Topic) && e.Operation != "" {
+				return true, operator(e.Operation)
+			}
+		}
 	}
 	return false, OperatorNone
 }
 
+// SourceTransforms returns the transforms of all sources of the entry.
+func (e Entry) SourceTransforms() []TransformDefinition {
+	var transforms []TransformDefinition
+	if e.Source.HttpSource != nil {
+		for _, u := range e.Source.HttpSource.Urls {
+			transforms = append(transforms, u.Transform)
+		}
+	}
+	if e.Source.MqttSource != nil {
+		for _, t := range e.Source.MqttSource.TopicsToSubscribe {
+			transforms = append(transforms, t.Transform)
+		}
+	}
+	if e.Source.TibberApiSource != nil {
+		transforms = append(transforms, e.Source.TibberApiSource.Transform)
+	}
+	return transforms
+}
+
+// HasTextValue reports whether the entry publishes text (string or bool) values.
+func (e Entry) HasTextValue() bool {
+	for _, t := range e.SourceTransforms() {
+		if valueType(t.ValueType).IsText() {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceIDs returns the ids under which the sources of the entry are stored in
+// the accumulation state, in configured order (mqtt topic or http url). The
+// topics matched by a wildcard topic are stored under the matched topic, see
+// MatchesSourceID.
+func (e Entry) SourceIDs() []string {
+	var ids []string
+	if e.Source.HttpSource != nil {
+		for _, u := range e.Source.HttpSource.Urls {
+			ids = append(ids, u.Url)
+		}
+	}
+	if e.Source.MqttSource != nil {
+		for _, t := range e.Source.MqttSource.TopicsToSubscribe {
+			ids = append(ids, t.Topic)
+		}
+	}
+	return ids
+}
+
+// MatchesSourceID reports whether id is a source of the entry, a configured
+// source id or a topic matched by a wildcard topic.
+func (e Entry) MatchesSourceID(id string) bool {
+	for _, sid := range e.SourceIDs() {
+		if sid == id {
+			return true
+		}
+		if _, ok := MatchTopic(sid, id); ok && IsWildcard(sid) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fingerprint hashes the yaml representation of the entry and the resolved qos
+// and retain flags, which follow the defaults of the mqtt section. Two entries
+// with the same fingerprint are configured identically.
+func (e Entry) Fingerprint() string {
+	data, err := yaml.Marshal(e)
+	if err != nil {
+		return HashStrings(16, e.Name)
+	}
+	resolved := ""
+	if e.Source.MqttSource != nil {
+		for _, t := range e.Source.MqttSource.TopicsToSubscribe {
+			resolved += fmt.Sprintf("%d,", t.ResolvedQos)
+		}
+	}
+	for _, t := range e.TopicsToPublish {
+		resolved += fmt.Sprintf("%d/%t,", t.ResolvedQos, t.ResolvedRetain)
+	}
+	return HashStrings(16, string(data), resolved)
+}
+
 func (t MqttTopicDefinition) GetIgnoreLessThanConfig() (hasLessThanConfig bool, lessThan float64) {
 	if t.Filter == nil {
 		return false, 0
@@ -0,0 +1,332 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/oliveagle/jsonpath"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Problem is a mistake in the config, at Line of File if known. Warnings don't
+// fail loading the config, e.g. an entry without source that does nothing.
+type Problem struct {
+	File    string
+	Line    int
+	Message string
+	Warning bool
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.File != "" && p.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	case p.File != "":
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return p.Message
+}
+
+// ValidationError is returned by LoadConfig with all problems of a config.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validator collects the problems of a config. The problems of entries are
+// positioned at the yaml nodes of the files the entries were loaded from.
+//
+// The config is decoded by yaml.v2, yaml.v3 is only used for the lines of its
+// nodes, as yaml.v2 has no node positions. Both parsers agree on the keys and
+// sequences a problem is looked up by. Where they differ it doesn't change the
+// line: scalars like yes and on are bools for yaml.v2 but strings for yaml.v3,
+// only the keys are compared. Duplicate keys are accepted by yaml.v3 but make
+// yaml.v2 fail the file before any lookup. Merge keys and aliases are followed
+// like yaml.v2 decodes them, see mappingValue.
+type validator struct {
+	problems []Problem
+	nodes    map[string]*yamlv3.Node
+	// files holds the files in load order, to sort the problems
+	files []string
+	// entries and brokers hold the file and the index in the file of each
+	// entry and broker
+	entries []entryPosition
+	brokers []entryPosition
+}
+
+type entryPosition struct {
+	file  string
+	index int
+}
+
+func newValidator() *validator {
+	return &validator{nodes: map[string]*yamlv3.Node{}}
+}
+
+// parse keeps the yaml nodes of a file to look up the lines of problems.
+func (v *validator) parse(file string, data []byte) {
+	v.files = append(v.files, file)
+	var n yamlv3.Node
+	if yamlv3.Unmarshal(data, &n) == nil {
+		v.nodes[file] = &n
+	}
+}
+
+// add records err at the node at path in file, path holds mapping keys and
+// sequence indexes, e.g. "dispatcher-entries", 3, "source".
+func (v *validator) add(file string, warning bool, err error, path ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		File:    file,
+		Line:    nodeLine(v.nodes[file], path...),
+		Message: err.Error(),
+		Warning: warning,
+	})
+}
+
+// entryError records err at the node at path in the entry with index e_i.
+func (v *validator) entryError(e_i int, err error, path ...interface{}) {
+	v.entryProblem(e_i, false, err, path...)
+}
+
+// entryWarning records a warning at the node at path in the entry with index e_i.
+func (v *validator) entryWarning(e_i int, err error, path ...interface{}) {
+	v.entryProblem(e_i, true, err, path...)
+}
+
+func (v *validator) entryProblem(e_i int, warning bool, err error, path ...interface{}) {
+	pos := v.entries[e_i]
+	v.add(pos.file, warning, err, append([]interface{}{"dispatcher-entries", pos.index}, path...)...)
+}
+
+// brokerError records err at the node at path in the broker with index b_i.
+func (v *validator) brokerError(b_i int, err error, path ...interface{}) {
+	pos := v.brokers[b_i]
+	v.add(pos.file, false, err, append([]interface{}{"brokers", pos.index}, path...)...)
+}
+
+// entryLine returns the position of the entry with index e_i, e.g. config.yaml:12.
+func (v *validator) entryLine(e_i int) string {
+	pos := v.entries[e_i]
+	return fmt.Sprintf("%s:%d", pos.file, nodeLine(v.nodes[pos.file], "dispatcher-entries", pos.index))
+}
+
+var unknownField = regexp.MustCompile(`^line (\d+): field (\S+) not found in type \S+$`)
+
+// addUnknownKeys records the unknown keys reported by yaml.UnmarshalStrict as
+// problems, it returns false if err has other errors.
+func (v *validator) addUnknownKeys(file string, errs []string) bool {
+	var problems []Problem
+	for _, e := range errs {
+		m := unknownField.FindStringSubmatch(e)
+		if m == nil {
+			return false
+		}
+		line, _ := strconv.Atoi(m[1])
+		problems = append(problems, Problem{File: file, Line: line, Message: fmt.Sprintf("ERROR: UNKNOWN KEY '%s'", m[2])})
+	}
+	v.problems = append(v.problems, problems...)
+	return true
+}
+
+// warnings returns the warnings.
+func (v *validator) warnings() []Problem {
+	var warnings []Problem
+	for _, p := range v.sorted() {
+		if p.Warning {
+			warnings = append(warnings, p)
+		}
+	}
+	return warnings
+}
+
+// err returns a ValidationError with all problems if there is at least one
+// error, warnings alone are no error.
+func (v *validator) err() error {
+	for _, p := range v.problems {
+		if !p.Warning {
+			return &ValidationError{Problems: v.sorted()}
+		}
+	}
+	return nil
+}
+
+// sorted returns the problems ordered by file, in load order, and line.
+func (v *validator) sorted() []Problem {
+	rank := map[string]int{}
+	for i, f := range v.files {
+		if _, ok := rank[f]; !ok {
+			rank[f] = i
+		}
+	}
+	problems := append([]Problem(nil), v.problems...)
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		if rank[a.File] != rank[b.File] {
+			return rank[a.File] < rank[b.File]
+		}
+		return a.Line < b.Line
+	})
+	return problems
+}
+
+// nodeLine returns the line of the node at path below n, or of the deepest node
+// found if the path does not exist, e.g. for keys set by a template.
+func nodeLine(n *yamlv3.Node, path ...interface{}) int {
+	if n == nil {
+		return 0
+	}
+	if n.Kind == yamlv3.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	line := n.Line
+	for _, p := range path {
+		var next *yamlv3.Node
+		n = resolveAlias(n)
+		switch p := p.(type) {
+		case string:
+			if key, value := mappingValue(n, p); key != nil {
+				line, next = key.Line, value
+			}
+		case int:
+			if n.Kind == yamlv3.SequenceNode && p < len(n.Content) {
+				next = n.Content[p]
+				line = next.Line
+			}
+		}
+		if next == nil {
+			return line
+		}
+		n = next
+	}
+	return line
+}
+
+// mappingValue returns the key and value nodes of key in the mapping n. Like
+// yaml.v2, keys of n win over the ones of its merge keys, <<: *anchor or
+// <<: [*a, *b], and earlier merged mappings over later ones.
+func mappingValue(n *yamlv3.Node, key string) (*yamlv3.Node, *yamlv3.Node) {
+	if n.Kind != yamlv3.MappingNode {
+		return nil, nil
+	}
+	var merges []*yamlv3.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		switch k := n.Content[i]; {
+		case k.Tag == "!!merge":
+			merges = append(merges, n.Content[i+1])
+		case k.Value == key:
+			return k, n.Content[i+1]
+		}
+	}
+	for _, m := range merges {
+		m = resolveAlias(m)
+		sources := []*yamlv3.Node{m}
+		if m.Kind == yamlv3.SequenceNode {
+			sources = m.Content
+		}
+		for _, src := range sources {
+			if k, v := mappingValue(resolveAlias(src), key); k != nil {
+				return k, v
+			}
+		}
+	}
+	return nil, nil
+}
+
+// resolveAlias returns the anchored node of an alias, *anchor, or n itself.
+func resolveAlias(n *yamlv3.Node) *yamlv3.Node {
+	for n.Kind == yamlv3.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+// validateEntry checks the sources, intervals, json paths and output formats of
+// the entry with index e_i.
+func (v *validator) validateEntry(e_i int, e Entry) {
+	src := e.Source
+	var sources []string
+	if src.MqttSource != nil {
+		sources = append(sources, "mqtt")
+	}
+	if src.HttpSource != nil {
+		sources = append(sources, "http")
+	}
+	if src.TibberApiSource != nil {
+		sources = append(sources, "tibber-api")
+	}
+	switch {
+	case len(sources) == 0:
+		v.entryWarning(e_i, fmt.Errorf("WARNING: ENTRY HAS NO SOURCE INDEX %d", e_i))
+	case len(sources) > 1:
+		v.entryError(e_i, fmt.Errorf("ERROR: ENTRY HAS MULTIPLE SOURCES (%s) INDEX %d", strings.Join(sources, ", "), e_i), "source")
+	}
+
+	if len(e.TopicsToPublish) == 0 {
+		v.entryWarning(e_i, fmt.Errorf("WARNING: ENTRY HAS NO TOPICS-TO-PUBLISH INDEX %d", e_i))
+	}
+
+	checkJsonPath := func(t TransformDefinition, path ...interface{}) {
+		if t.JsonPath == "" {
+			return
+		}
+		if _, err := jsonpath.Compile(t.JsonPath); err != nil {
+			v.entryError(e_i, fmt.Errorf("ERROR: INVALID JSONPATH '%s': %v INDEX %d", t.JsonPath, err, e_i), append(path, "transform", "jsonPath")...)
+		}
+	}
+	checkInterval := func(interval int, path ...interface{}) {
+		if interval <= 0 {
+			v.entryError(e_i, fmt.Errorf("ERROR: INTERVAL_SEC MUST BE POSITIVE INDEX %d: %d", e_i, interval), append(path, "interval_sec")...)
+		}
+	}
+	if src.MqttSource != nil {
+		for t_i, t := range src.MqttSource.TopicsToSubscribe {
+			checkJsonPath(t.Transform, "source", "mqtt", "topics-to-subscribe", t_i)
+		}
+	}
+	if src.HttpSource != nil {
+		checkInterval(src.HttpSource.IntervalSec, "source", "http")
+		for u_i, u := range src.HttpSource.Urls {
+			checkJsonPath(u.Transform, "source", "http", "urls", u_i)
+		}
+	}
+	if src.TibberApiSource != nil {
+		checkInterval(src.TibberApiSource.IntervalSec, "source", "tibber-api")
+		checkJsonPath(src.TibberApiSource.Transform, "source", "tibber-api")
+	}
+
+	var smoke interface{} = 1.0
+	if e.HasTextValue() {
+		smoke = ""
+	}
+	for t_i, t := range e.TopicsToPublish {
+		if err := checkOutputFormat(t.Transform.OutputFormat, smoke); err != nil {
+			v.entryError(e_i, fmt.Errorf("%v INDEX %d TOPIC %d", err, e_i, t_i), "topics-to-publish", t_i, "transform", "outputFormat")
+		}
+	}
+}
+
+// checkOutputFormat formats smoke with format, which fails for verbs that don't
+// match the value type, a missing verb or too many verbs.
+func checkOutputFormat(format string, smoke interface{}) error {
+	if format == "" {
+		return nil
+	}
+	if s := fmt.Sprintf(format, smoke); strings.Contains(s, "%!") {
+		kind := "NUMBER"
+		if _, ok := smoke.(string); ok {
+			kind = "TEXT"
+		}
+		return fmt.Errorf("ERROR: INVALID OUTPUTFORMAT '%s' FOR %s VALUES: '%s'", format, kind, s)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigProblems(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - name: "power"
+    diabled: true
+    topics-to-publish:
+      - topic: "awtrix/custom/power"
+        transform:
+          outputFormat: "%d W"
+    source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "power"
+            transform:
+              jsonPath: "$.power["
+      http:
+        interval_sec: 10
+        urls:
+          - url: "http://shelly/status"
+  - name: "power"
+    source:
+      http:
+        urls:
+          - url: "http://shelly/status"
+  - name: "solar"
+    topics-to-publish:
+      - topic: "awtrix/custom/solar"
+        transform:
+          outputFormat: "%.0f W"
+`), nil
+	}
+
+	cfg, err := LoadConfig("config.yaml")
+	assert.Nil(t, cfg)
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+
+	var problems []string
+	for _, p := range verr.Problems {
+		problems = append(problems, p.String())
+	}
+	assert.Equal(t, []string{
+		"config.yaml:5: ERROR: UNKNOWN KEY 'diabled'",
+		"config.yaml:9: ERROR: INVALID OUTPUTFORMAT '%d W' FOR NUMBER VALUES: '%!d(float64=1) W' INDEX 0 TOPIC 0",
+		"config.yaml:10: ERROR: ENTRY HAS MULTIPLE SOURCES (mqtt, http) INDEX 0",
+		"config.yaml:15: ERROR: INVALID JSONPATH '$.power[': len(tail) should >=3, [ INDEX 0",
+		"config.yaml:20: ERROR: DUPLICATE ENTRY NAME 'power', FIRST DEFINED AT config.yaml:4 INDEX 1",
+		"config.yaml:20: WARNING: ENTRY HAS NO TOPICS-TO-PUBLISH INDEX 1",
+		"config.yaml:22: ERROR: INTERVAL_SEC MUST BE POSITIVE INDEX 1: 0",
+		"config.yaml:25: WARNING: ENTRY HAS NO SOURCE INDEX 2",
+	}, problems)
+}
+
+func TestLoadConfigWarnings(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - name: "draft"
+`), nil
+	}
+
+	cfg, err := LoadConfig("config.yaml")
+	require.NoError(t, err)
+	require.Len(t, cfg.Warnings(), 2)
+	assert.Equal(t, "config.yaml:4: WARNING: ENTRY HAS NO SOURCE INDEX 0", cfg.Warnings()[0].String())
+}
+
+// The config is decoded by yaml.v2 and positioned with yaml.v3, the lines have
+// to match what yaml.v2 loads where the parsers differ.
+func TestLoadConfigParserDifferences(t *testing.T) {
+	// Merged keys are positioned at the anchor yaml.v2 took them from.
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - name: "power"
+    source:
+      http: &http
+        interval_sec: 0
+        urls:
+          - url: "http://shelly/power"
+    topics-to-publish:
+      - topic: "awtrix/custom/power"
+  - name: "solar"
+    source:
+      http:
+        <<: *http
+        urls:
+          - url: "http://shelly/solar"
+    topics-to-publish: &topics
+      - topic: "awtrix/custom/solar"
+        transform:
+          outputFormat: "%d W"
+  - name: "house"
+    source:
+      http:
+        <<: [{interval_sec: 10}, *http]
+        urls:
+          - url: "http://shelly/house"
+    topics-to-publish: *topics
+`), nil
+	}
+	_, err := LoadConfig("config.yaml")
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	var problems []string
+	for _, p := range verr.Problems {
+		problems = append(problems, p.String())
+	}
+	assert.Equal(t, []string{
+		"config.yaml:7: ERROR: INTERVAL_SEC MUST BE POSITIVE INDEX 0: 0",
+		"config.yaml:7: ERROR: INTERVAL_SEC MUST BE POSITIVE INDEX 1: 0",
+		"config.yaml:21: ERROR: INVALID OUTPUTFORMAT '%d W' FOR NUMBER VALUES: '%!d(float64=1) W' INDEX 1 TOPIC 0",
+		"config.yaml:21: ERROR: INVALID OUTPUTFORMAT '%d W' FOR NUMBER VALUES: '%!d(float64=1) W' INDEX 2 TOPIC 0",
+	}, problems)
+
+	// yes is a bool for yaml.v2 but a string for yaml.v3, only keys are looked up.
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+  retain: yes
+dispatcher-entries:
+  - name: "power"
+    source:
+      http:
+        interval_sec: 10
+        urls:
+          - url: "http://shelly/power"
+    topics-to-publish:
+      - topic: "awtrix/custom/power"
+`), nil
+	}
+	cfg, err := LoadConfig("config.yaml")
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Mqtt.Retain)
+	assert.True(t, *cfg.Mqtt.Retain)
+
+	// Duplicate keys are accepted by yaml.v3, yaml.v2 fails the file.
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+  broker: "tcp://other:1883"
+`), nil
+	}
+	cfg, err = LoadConfig("config.yaml")
+	assert.Nil(t, cfg)
+	assert.ErrorContains(t, err, "config.yaml: yaml: unmarshal errors:")
+	assert.ErrorContains(t, err, "already set")
+}
+
+func TestCheckOutputFormat(t *testing.T) {
+	assert.NoError(t, checkOutputFormat("%.1f °C", 1.0))
+	assert.NoError(t, checkOutputFormat("%v%%", 1.0))
+	assert.NoError(t, checkOutputFormat("Door %s", ""))
+	assert.ErrorContains(t, checkOutputFormat("%s W", 1.0), "FOR NUMBER VALUES")
+	assert.ErrorContains(t, checkOutputFormat("%.1f", ""), "FOR TEXT VALUES")
+	assert.ErrorContains(t, checkOutputFormat("W", 1.0), "%!(EXTRA")
+	assert.ErrorContains(t, checkOutputFormat("%f %f", 1.0), "MISSING")
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// validateValueTypes checks value-type and mapping of the source transforms.
+// Text values are published as they are, so they cannot be inverted,
+// accumulated or filtered, and value-type and mapping are not allowed on
+// publish topics.
+func validateValueTypes(e Entry) error {
+	for _, t := range e.SourceTransforms() {
+		vt := valueType(t.ValueType)
+		switch vt {
+		case "", ValueTypeNumber, ValueTypeString, ValueTypeBool:
+		default:
+			return fmt.Errorf("ERROR: INVALID VALUE-TYPE '%s'", t.ValueType)
+		}
+		if len(t.Mapping) > 0 && !vt.IsText() {
+			return errors.New("ERROR: MAPPING REQUIRES VALUE-TYPE STRING OR BOOL")
+		}
+		if vt == ValueTypeBool {
+			for k := range t.Mapping {
+				if k != "true" && k != "false" {
+					return fmt.Errorf("ERROR: BOOL MAPPING KEYS MUST BE 'true' OR 'false', GOT '%s'", k)
+				}
+			}
+		}
+		if vt.IsText() && t.Invert {
+			return errors.New("ERROR: TEXT VALUES CANNOT BE INVERTED")
+		}
+	}
+
+	if e.HasTextValue() {
+		if must, _ := e.MustAccumulate(); must {
+			return errors.New("ERROR: TEXT VALUES CANNOT BE ACCUMULATED")
+		}
+		for _, p := range e.TopicsToPublish {
+			if p.Filter != nil {
+				return errors.New("ERROR: TEXT VALUES CANNOT BE FILTERED")
+			}
+		}
+	}
+
+	for _, p := range e.TopicsToPublish {
+		if p.Transform.ValueType != "" || len(p.Transform.Mapping) > 0 {
+			return errors.New("ERROR: VALUE-TYPE AND MAPPING ARE ONLY ALLOWED ON SOURCE TRANSFORMS")
+		}
+	}
+	return nil
+}
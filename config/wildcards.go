@@ -0,0 +1,148 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// topicPlaceholder matches a {n} in a publish topic, which is replaced by the
+// n-th wildcard segment of the matched source topic, counting from 1.
+var topicPlaceholder = regexp.MustCompile(`\{(\d+)\}`)
+
+// IsWildcard reports whether the mqtt topic filter contains a + or # wildcard.
+func IsWildcard(filter string) bool {
+	return strings.ContainsAny(filter, "+#")
+}
+
+// validateWildcards checks that + and # are whole levels of filter and that #
+// is the last level.
+func validateWildcards(filter string) error {
+	levels := strings.Split(filter, "/")
+	for i, l := range levels {
+		switch {
+		case l == "#" && i != len(levels)-1:
+			return fmt.Errorf("ERROR: # MUST BE THE LAST LEVEL OF TOPIC '%s'", filter)
+		case l != "+" && l != "#" && strings.ContainsAny(l, "+#"):
+			return fmt.Errorf("ERROR: WILDCARDS MUST BE WHOLE LEVELS OF TOPIC '%s'", filter)
+		}
+	}
+	return nil
+}
+
+// wildcardCount returns the number of wildcards of filter.
+func wildcardCount(filter string) int {
+	n := 0
+	for _, l := range strings.Split(filter, "/") {
+		if l == "+" || l == "#" {
+			n++
+		}
+	}
+	return n
+}
+
+// MatchTopic reports whether topic matches the topic filter and returns the
+// segments matched by its wildcards. A # captures the remaining levels. A
+// shared subscription $share/group/filter matches like filter. A wildcard at
+// the first level doesn't match topics starting with $, e.g. $SYS/broker/uptime
+// (MQTT 4.7.2).
+func MatchTopic(filter, topic string) ([]string, bool) {
+	if shared, ok := strings.CutPrefix(filter, sharePrefix); ok {
+		if _, f, ok := strings.Cut(shared, "/"); ok {
+			filter = f
+		}
+	}
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	if (f[0] == "+" || f[0] == "#") && strings.HasPrefix(topic, "$") {
+		return nil, false
+	}
+	var captures []string
+	for i, l := range f {
+		switch {
+		case l == "#":
+			// # also matches the parent level, e.g. a/# matches a
+			return append(captures, strings.Join(t[min(i, len(t)):], "/")), true
+		case i >= len(t):
+			return nil, false
+		case l == "+":
+			captures = append(captures, t[i])
+		case l != t[i]:
+			return nil, false
+		}
+	}
+	if len(f) != len(t) {
+		return nil, false
+	}
+	return captures, true
+}
+
+// HasTopicPlaceholders reports whether the publish topic uses wildcard segments.
+func HasTopicPlaceholders(topic string) bool {
+	return topicPlaceholder.MatchString(topic)
+}
+
+// ExpandTopic replaces the placeholders of the publish topic with the captured
+// wildcard segments.
+func ExpandTopic(topic string, captures []string) string {
+	return topicPlaceholder.ReplaceAllStringFunc(topic, func(p string) string {
+		n, _ := strconv.Atoi(p[1 : len(p)-1])
+		if n < 1 || n > len(captures) {
+			return p
+		}
+		return captures[n-1]
+	})
+}
+
+// maxTopicPlaceholder returns the highest placeholder of the publish topic.
+func maxTopicPlaceholder(topic string) int {
+	highest := 0
+	for _, m := range topicPlaceholder.FindAllStringSubmatch(topic, -1) {
+		if n, _ := strconv.Atoi(m[1]); n > highest {
+			highest = n
+		}
+	}
+	return highest
+}
+
+// validateTopics checks the wildcards of the subscribe topics and the
+// placeholders of the publish topics of the entry with index e_i. Every
+// subscribe topic needs a wildcard for each placeholder.
+func (v *validator) validateTopics(e_i int, e Entry) {
+	var filters []string
+	if e.Source.MqttSource != nil {
+		for t_i, t := range e.Source.MqttSource.TopicsToSubscribe {
+			if err := validateWildcards(t.Topic); err != nil {
+				v.entryError(e_i, fmt.Errorf("%v INDEX %d", err, e_i), "source", "mqtt", "topics-to-subscribe", t_i, "topic")
+				continue
+			}
+			filters = append(filters, t.Topic)
+		}
+	}
+
+	for t_i, t := range e.TopicsToPublish {
+		path := []interface{}{"topics-to-publish", t_i, "topic"}
+		if IsWildcard(t.Topic) {
+			v.entryError(e_i, fmt.Errorf("ERROR: PUBLISH TOPIC MUST NOT CONTAIN WILDCARDS '%s' INDEX %d TOPIC %d", t.Topic, e_i, t_i), path...)
+			continue
+		}
+		n := maxTopicPlaceholder(t.Topic)
+		if n == 0 {
+			continue
+		}
+		if len(filters) == 0 {
+			v.entryError(e_i, fmt.Errorf("ERROR: PUBLISH TOPIC '%s' USES {%d} WITHOUT A WILDCARD SOURCE INDEX %d TOPIC %d", t.Topic, n, e_i, t_i), path...)
+			continue
+		}
+		for _, f := range filters {
+			if wildcardCount(f) < n {
+				v.entryError(e_i, fmt.Errorf("ERROR: PUBLISH TOPIC '%s' USES {%d} BUT TOPIC '%s' HAS %d WILDCARDS INDEX %d TOPIC %d", t.Topic, n, f, wildcardCount(f), e_i, t_i), path...)
+				break
+			}
+		}
+		if e.HasFallback() {
+			v.entryError(e_i, fmt.Errorf("ERROR: FALLBACK IS NOT SUPPORTED WITH PUBLISH TOPIC PLACEHOLDERS INDEX %d TOPIC %d", e_i, t_i), path...)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		captures      []string
+		ok            bool
+	}{
+		{"a/b", "a/b", nil, true},
+		{"a/b", "a/c", nil, false},
+		{"shellies/+/status/switch:0", "shellies/plug-1/status/switch:0", []string{"plug-1"}, true},
+		{"shellies/+/status/+", "shellies/plug-1/status/switch:0", []string{"plug-1", "switch:0"}, true},
+		{"shellies/+/status", "shellies/plug-1/status/switch:0", nil, false},
+		{"shellies/+/status/switch:0", "shellies/status/switch:0", nil, false},
+		{"shellies/#", "shellies/plug-1/status", []string{"plug-1/status"}, true},
+		{"shellies/#", "shellies", []string{""}, true},
+		{"+/#", "shellies/plug-1", []string{"shellies", "plug-1"}, true},
+		{"#", "$SYS/broker/uptime", nil, false},
+		{"+/broker/uptime", "$SYS/broker/uptime", nil, false},
+		{"$share/group/#", "$SYS/broker/uptime", nil, false},
+		{"$SYS/#", "$SYS/broker/uptime", []string{"broker/uptime"}, true},
+		{"$SYS/+/uptime", "$SYS/broker/uptime", []string{"broker"}, true},
+		{"shellies/+", "shellies/$state", []string{"$state"}, true},
+	}
+	for _, tt := range tests {
+		captures, ok := MatchTopic(tt.filter, tt.topic)
+		assert.Equal(t, tt.ok, ok, "%s %s", tt.filter, tt.topic)
+		assert.Equal(t, tt.captures, captures, "%s %s", tt.filter, tt.topic)
+	}
+}
+
+func TestExpandTopic(t *testing.T) {
+	assert.Equal(t, "awtrix/custom/plug-1", ExpandTopic("awtrix/custom/{1}", []string{"plug-1"}))
+	assert.Equal(t, "awtrix/switch:0/plug-1", ExpandTopic("awtrix/{2}/{1}", []string{"plug-1", "switch:0"}))
+	assert.Equal(t, "awtrix/custom/{2}", ExpandTopic("awtrix/custom/{2}", []string{"plug-1"}))
+	assert.False(t, HasTopicPlaceholders("awtrix/custom/power"))
+}
+
+func TestLoadConfigWildcardErrors(t *testing.T) {
+	osReadFile = func(path string) ([]byte, error) {
+		return []byte(`mqtt:
+  broker: "tcp://localhost:1883"
+dispatcher-entries:
+  - name: "plugs"
+    source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "shellies/plug+/status"
+          - topic: "shellies/#/status"
+          - topic: "shellies/+/status/switch:0"
+    topics-to-publish:
+      - topic: "awtrix/custom/+"
+      - topic: "awtrix/custom/{2}"
+  - name: "power"
+    source:
+      http:
+        interval_sec: 10
+        urls:
+          - url: "http://shelly/status"
+    topics-to-publish:
+      - topic: "awtrix/custom/{1}"
+`), nil
+	}
+
+	cfg, err := LoadConfig("config.yaml")
+	assert.Nil(t, cfg)
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+
+	var problems []string
+	for _, p := range verr.Problems {
+		problems = append(problems, p.String())
+	}
+	assert.Equal(t, []string{
+		"config.yaml:8: ERROR: WILDCARDS MUST BE WHOLE LEVELS OF TOPIC 'shellies/plug+/status' INDEX 0",
+		"config.yaml:9: ERROR: # MUST BE THE LAST LEVEL OF TOPIC 'shellies/#/status' INDEX 0",
+		"config.yaml:12: ERROR: PUBLISH TOPIC MUST NOT CONTAIN WILDCARDS 'awtrix/custom/+' INDEX 0 TOPIC 0",
+		"config.yaml:13: ERROR: PUBLISH TOPIC 'awtrix/custom/{2}' USES {2} BUT TOPIC 'shellies/+/status/switch:0' HAS 1 WILDCARDS INDEX 0 TOPIC 1",
+		"config.yaml:21: ERROR: PUBLISH TOPIC 'awtrix/custom/{1}' USES {1} WITHOUT A WILDCARD SOURCE INDEX 1 TOPIC 0",
+	}, problems)
+}
+
+func TestMatchesSourceID(t *testing.T) {
+	e := Entry{Source: EntrySource{MqttSource: &MqttSource{TopicsToSubscribe: []MqttTopicDefinition{
+		{Topic: "shellies/+/status/switch:0"},
+		{Topic: "grid/power"},
+	}}}}
+	assert.True(t, e.MatchesSourceID("shellies/plug-1/status/switch:0"))
+	assert.True(t, e.MatchesSourceID("grid/power"))
+	assert.False(t, e.MatchesSourceID("grid/solar"))
+}
@@ -0,0 +1,18 @@
+package dispatcher
+
+// WithBroker adds the client of the broker named name, which entries select
+// with source-broker and publish-broker.
+func WithBroker(name string, client MqttClient) Option {
+	return func(d *Dispatcher) {
+		d.brokers[name] = client
+	}
+}
+
+// client returns the client of the named broker, the default client for "" and
+// nil for an unknown broker.
+func (d *Dispatcher) client(broker string) MqttClient {
+	if broker == "" {
+		return d.mqttClient
+	}
+	return d.brokers[broker]
+}
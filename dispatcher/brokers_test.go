@@ -0,0 +1,44 @@
+package dispatcher
+
+import (
+	"context"
+	"go-mqtt-dispatcher/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrokers(t *testing.T) {
+	log := func(s string) { t.Log(s) }
+	def := NewMockMqttClient(log)
+	shellies := NewMockMqttClient(log)
+	awtrix := NewMockMqttClient(log)
+
+	bridged := newMqttEntry("bridged", "sub/power", "pub/power")
+	bridged.Source.MqttSource.TopicsToSubscribe[0].ResolvedBroker = "shellies"
+	bridged.TopicsToPublish[0].ResolvedBroker = "awtrix"
+	local := newMqttEntry("local", "sub/power", "pub/local")
+
+	d, err := NewDispatcher(&[]config.Entry{bridged, local}, def, newTestLogger(t), WithBroker("shellies", shellies), WithBroker("awtrix", awtrix))
+	require.NoError(t, err)
+	d.Run(context.Background())
+	defer d.Stop()
+
+	// The same topic on different brokers are separate subscriptions.
+	assert.True(t, shellies.IsSubscribed("sub/power"))
+	assert.True(t, def.IsSubscribed("sub/power"))
+	assert.False(t, awtrix.IsSubscribed("sub/power"))
+
+	shellies.SimulateMessage("sub/power", []byte(`5`))
+	assert.Equal(t, `{"text":"5"}`, lastMessage(awtrix, "pub/power"))
+	_, ok := def.GetPublishedMessage("pub/local")
+	assert.False(t, ok)
+
+	def.SimulateMessage("sub/power", []byte(`6`))
+	assert.Equal(t, `{"text":"6"}`, lastMessage(def, "pub/local"))
+	assert.Equal(t, `{"text":"5"}`, lastMessage(awtrix, "pub/power"))
+
+	awtrix.SetConnected(false)
+	assert.False(t, d.IsConnected())
+}
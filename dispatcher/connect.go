@@ -0,0 +1,50 @@
+package dispatcher
+
+import (
+	"go-mqtt-dispatcher/config"
+	"net/url"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqtt5KeepAlive is the keep alive of MQTT 5 connections in seconds.
+const mqtt5KeepAlive = 30
+
+// NewClientOptions returns the paho options to connect to the MQTT 3.1.1
+// broker of cfg, with the tls config built by config.LoadConfig for secure
+// schemes and the status topic as last will.
+func NewClientOptions(clientId string, cfg config.MqttConfig) *mqtt.ClientOptions {
+	opts := mqtt.NewClientOptions()
+	// paho handles tcp://, mqtt://, ssl://, mqtts://, ws:// and wss:// itself,
+	// the path is kept for websocket brokers (e.g. wss://host:443/mqtt)
+	opts.AddBroker(cfg.BrokerAsUri.String())
+	if cfg.Username != "" {
+		opts.SetUsername(cfg.Username)
+	}
+	if cfg.Password != "" {
+		opts.SetPassword(cfg.Password)
+	}
+	if cfg.TLSConfig != nil {
+		opts.SetTLSConfig(cfg.TLSConfig)
+	}
+	opts.SetClientID(clientId)
+	if cfg.StatusTopic != "" {
+		SetStatusWill(opts, cfg.StatusTopic)
+	}
+	return opts
+}
+
+// NewMqtt5ClientConfig returns the autopaho config to connect to the MQTT 5
+// broker of cfg, see NewClientOptions.
+func NewMqtt5ClientConfig(clientId string, cfg config.MqttConfig) autopaho.ClientConfig {
+	return autopaho.ClientConfig{
+		ServerUrls:      []*url.URL{cfg.BrokerAsUri},
+		TlsCfg:          cfg.TLSConfig,
+		KeepAlive:       mqtt5KeepAlive,
+		ConnectUsername: cfg.Username,
+		ConnectPassword: []byte(cfg.Password),
+		ClientConfig:    paho.ClientConfig{ClientID: clientId},
+	}
+}
@@ -0,0 +1,167 @@
+package dispatcher
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"go-mqtt-dispatcher/config"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCert is a generated certificate with its key, signed by parent or self-signed.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, ips ...net.IP) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  ips,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writeFiles writes the certificate and key as pem files to dir.
+func (c *testCert) writeFiles(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return certFile, keyFile
+}
+
+// startTLSBroker accepts tls connections signed by ca, requiring a client
+// certificate of ca, and reports the handshake results.
+func startTLSBroker(t *testing.T, ca, server *testCert) (string, <-chan error) {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.der}, PrivateKey: server.key}},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	handshakes := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			handshakes <- conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), handshakes
+}
+
+func loadTLSConfig(t *testing.T, dir, broker, caFile, certFile, keyFile string) (*config.RootConfig, error) {
+	t.Helper()
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`mqtt:
+  broker: "`+broker+`"
+  ca-file: "`+caFile+`"
+  client-cert-file: "`+certFile+`"
+  client-key-file: "`+keyFile+`"
+dispatcher-entries:
+  - name: "power"
+    source:
+      mqtt:
+        topics-to-subscribe:
+          - topic: "power"
+`), 0o600))
+	return config.LoadConfig(path)
+}
+
+func TestClientOptionsTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test ca", nil)
+	server := newTestCert(t, "broker", ca, net.IPv4(127, 0, 0, 1))
+	client := newTestCert(t, "dispatcher", ca)
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+	certFile, keyFile := client.writeFiles(t, dir, "client")
+	addr, handshakes := startTLSBroker(t, ca, server)
+
+	cfg, err := loadTLSConfig(t, dir, "mqtts://"+addr, caFile, certFile, keyFile)
+	require.NoError(t, err)
+
+	opts := NewClientOptions("dispatcher", cfg.Mqtt)
+	require.Len(t, opts.Servers, 1)
+	assert.Equal(t, "mqtts://"+addr, opts.Servers[0].String())
+	assert.Same(t, cfg.Mqtt.TLSConfig, NewMqtt5ClientConfig("dispatcher", cfg.Mqtt).TlsCfg)
+
+	conn, err := tls.Dial("tcp", addr, opts.TLSConfig)
+	require.NoError(t, err)
+	require.NoError(t, conn.Handshake())
+	conn.Close()
+	assert.NoError(t, <-handshakes)
+}
+
+func TestClientOptionsTLSUnknownCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test ca", nil)
+	server := newTestCert(t, "broker", ca, net.IPv4(127, 0, 0, 1))
+	client := newTestCert(t, "dispatcher", ca)
+	otherCaFile, _ := newTestCert(t, "other ca", nil).writeFiles(t, dir, "other-ca")
+	certFile, keyFile := client.writeFiles(t, dir, "client")
+	addr, _ := startTLSBroker(t, ca, server)
+
+	cfg, err := loadTLSConfig(t, dir, "mqtts://"+addr, otherCaFile, certFile, keyFile)
+	require.NoError(t, err)
+
+	_, err = tls.Dial("tcp", addr, NewClientOptions("dispatcher", cfg.Mqtt).TLSConfig)
+	var unknownAuthority x509.UnknownAuthorityError
+	assert.True(t, errors.As(err, &unknownAuthority), "got %v", err)
+}
+
+func TestClientOptionsTLSMissingClientCert(t *testing.T) {
+	dir := t.TempDir()
+	caFile, _ := newTestCert(t, "test ca", nil).writeFiles(t, dir, "ca")
+	_, keyFile := newTestCert(t, "dispatcher", nil).writeFiles(t, dir, "client")
+	missing := filepath.Join(dir, "missing.crt")
+
+	cfg, err := loadTLSConfig(t, dir, "mqtts://127.0.0.1:8883", caFile, missing, keyFile)
+	assert.Nil(t, cfg)
+	assert.ErrorContains(t, err, "ERROR READING CLIENT CERT FILE '"+missing+"'")
+}
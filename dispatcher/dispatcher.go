@@ -1,17 +1,21 @@
 package dispatcher
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go-mqtt-dispatcher/config"
 	httpsimple "go-mqtt-dispatcher/dispatcher/httpsimple"
 	tibberapi "go-mqtt-dispatcher/dispatcher/tibber-api"
+	"go-mqtt-dispatcher/logging"
 	tibbergraph "go-mqtt-dispatcher/tibber-graph"
 	"go-mqtt-dispatcher/utils"
+	"log/slog"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 	"unicode"
 
@@ -24,67 +28,244 @@ var now = func() time.Time { return time.Now() }
 
 type dispatcherState map[string]map[string]float64
 
+// dispatcherTimes records when each value of dispatcherState was received.
+type dispatcherTimes map[string]map[string]time.Time
+
 // fallbackTrack records freshness state for one publish topic of an entry with
 // a stale-value fallback configured.
 type fallbackTrack struct {
 	lastActivity time.Time // last time any payload was received (no-value-read)
-	lastValue    float64   // last resolved value (no-value-change)
+	lastValue    string    // last resolved value, formatted (no-value-change)
 	hasValue     bool      // lastValue is valid
 	lastChange   time.Time // last time the resolved value changed (no-value-change)
 	fired        bool      // fallback already published; suppresses re-fire until fresh data
 }
 
+// orderedValues returns the stored values of the entry's sources in configured
+// order and whether the first source has reported yet. The topics matched by a
+// wildcard topic are in the place of the wildcard topic, sorted by topic.
+// Callers hold d.mu.
+func (s dispatcherState) orderedValues(entry config.Entry) (values []float64, firstReported bool) {
+	for i, sid := range entry.SourceIDs() {
+		for _, id := range s.matchedIDs(entry.Name, sid) {
+			if i == 0 {
+				firstReported = true
+			}
+			values = append(values, s[entry.Name][id])
+		}
+	}
+	return values, firstReported
+}
+
+// matchedIDs returns the stored source ids of the entry matching the source id
+// sid, sorted. Callers hold d.mu.
+func (s dispatcherState) matchedIDs(entryName, sid string) []string {
+	if !config.IsWildcard(sid) {
+		if _, ok := s[entryName][sid]; ok {
+			return []string{sid}
+		}
+		return nil
+	}
+	var ids []string
+	for id := range s[entryName] {
+		if _, ok := config.MatchTopic(sid, id); ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 type Dispatcher struct {
 	entries    *[]config.Entry
 	state      dispatcherState
+	updated    dispatcherTimes
 	mqttClient MqttClient
-	log        func(string)
-
-	// mu guards the shared maps below (state and fallbacks), which are accessed
-	// concurrently by the per-source goroutines and the fallback watchdog.
-	mu        sync.Mutex
-	fallbacks map[string]*fallbackTrack // key = fallbackKey(entry.Name, pubTopic)
+	log        *slog.Logger
+
+	// mu guards the shared maps below (state, fallbacks, history, expired,
+	// status and listeners), which are accessed concurrently by the per-source
+	// goroutines and the fallback watchdog.
+	mu             sync.Mutex
+	fallbacks      map[string]*fallbackTrack     // key = fallbackKey(entry.Name, pubTopic)
+	history        map[string][]historySample    // key = fallbackKey(entry.Name, pubTopic)
+	expired        map[string]bool               // key = fallbackKey(entry.Name, sourceID)
+	status         map[string]*entryStatus       // key = entry.Name
+	listeners      map[uint64]func(PublishEvent) // added by AddPublishListener
+	nextListenerID uint64
+
+	// metrics records the prometheus metrics (optional).
+	metrics *Metrics
+	// errors counts the failures for the heartbeat.
+	errors  errorCounters
+	started time.Time
+
+	// heartbeatTopic receives a Heartbeat every heartbeatInterval (optional).
+	heartbeatTopic    string
+	heartbeatInterval time.Duration
+	version           string
+
+	// store persists state and fallbacks, saved every storeInterval (optional).
+	store         *StateStore
+	storeInterval time.Duration
+
+	// runCtx, cancel and running are set by Run and updated by Reload and Stop (guarded by mu).
+	runCtx  context.Context
+	cancel  context.CancelFunc
+	running map[string]*runningEntry // key = entryKeys() key
+	// wg tracks the poller, watchdog and subscription goroutines so Stop can wait for them.
+	wg sync.WaitGroup
+
+	// routes fans one broker subscription out to every entry using the topic (guarded by mu).
+	routes      map[routeKey][]route
+	nextRouteID uint64
+	// brokers are the clients of the named brokers, see WithBroker.
+	brokers map[string]MqttClient
+	// subMu serializes subscribe and unsubscribe calls to the broker.
+	subMu sync.Mutex
+	// dispatching is read locked while handlers run, so a removed route can
+	// wait for the handlers that got it before the removal.
+	dispatching sync.RWMutex
 }
 
-func NewDispatcher(entries *[]config.Entry, mqttClient MqttClient, log func(s string)) (*Dispatcher, error) {
+// NewDispatcher creates the dispatcher for entries. With WithStateStore the
+// saved state is restored, an unreadable state file is logged and ignored.
+func NewDispatcher(entries *[]config.Entry, mqttClient MqttClient, log *slog.Logger, opts ...Option) (*Dispatcher, error) {
 	if log == nil {
-		log = func(s string) {}
+		log = logging.Discard()
 	}
 
-	return &Dispatcher{
+	d := &Dispatcher{
 		entries:    entries,
 		state:      make(dispatcherState),
+		updated:    make(dispatcherTimes),
 		mqttClient: mqttClient,
 		log:        log,
 		fallbacks:  make(map[string]*fallbackTrack),
-	}, nil
+		history:    make(map[string][]historySample),
+		expired:    make(map[string]bool),
+		status:     make(map[string]*entryStatus),
+		listeners:  make(map[uint64]func(PublishEvent)),
+		running:    make(map[string]*runningEntry),
+		routes:     make(map[routeKey][]route),
+		brokers:    make(map[string]MqttClient),
+		started:    now(),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if d.store != nil {
+		if err := d.loadState(); err != nil {
+			d.log.Error("Error restoring state", "error", err)
+		}
+	}
+	return d, nil
 }
 
 func fallbackKey(entryName, pubTopic string) string {
 	return entryName + "\x00" + pubTopic
 }
 
-// Run starts the dispatcher and creates triggers for the sources and attaches the callbacks
-func (d *Dispatcher) Run() {
-	for _, entry := range *d.entries {
-		if entry.Disabled {
-			d.log("Entry disabled: " + entry.Name)
-			continue
-		}
+// entryLog returns the logger of an entry with its name, id and source type.
+// Entries with debug enabled log at debug level regardless of the configured level.
+func (d *Dispatcher) entryLog(e config.Entry) *slog.Logger {
+	log := d.log
+	if e.Debug {
+		log = logging.WithLevel(log, slog.LevelDebug)
+	}
+	attrs := []any{"entry", e.Name}
+	if id, ok := e.Identity(); ok {
+		attrs = append(attrs, "entry_id", id.GetID(), "source_type", id.GetTypeName())
+	}
+	return log.With(attrs...)
+}
+
+// Run starts the dispatcher and creates triggers for the sources and attaches the callbacks.
+// It returns immediately; the triggers run until ctx is cancelled or Stop is called.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	d.mu.Lock()
+	d.runCtx = ctx
+	d.cancel = cancel
+	entries := *d.entries
+	d.mu.Unlock()
+
+	for _, k := range entryKeys(entries) {
+		d.startEntry(ctx, k.key, k.entry)
+	}
+
+	if d.store != nil {
+		d.runStateSaver(ctx)
+	}
+
+	if d.heartbeatTopic != "" {
+		d.runHeartbeat(ctx)
+	}
+}
+
+// startEntry starts the triggers of a single entry with its own cancelable context.
+func (d *Dispatcher) startEntry(ctx context.Context, key string, entry config.Entry) {
+	ctx, cancel := context.WithCancel(ctx)
+	wg := &sync.WaitGroup{}
+	ctx = context.WithValue(ctx, entryWorkersKey{}, wg)
+	d.mu.Lock()
+	d.running[key] = &runningEntry{entry: entry, cancel: cancel, workers: wg}
+	d.mu.Unlock()
+
+	if entry.Disabled {
+		d.entryLog(entry).Info("Entry disabled")
+		return
+	}
+
+	if entry.HasFallback() {
+		d.startFallbackWatchdog(ctx, entry)
+	}
+
+	if entry.Source.MqttSource != nil {
+		mqttEntry := config.MqttEntryImpl{Entry: entry}
+		d.runMqtt(ctx, mqttEntry)
+	} else if entry.Source.HttpSource != nil {
+		httpEntry := config.HttpEntryImpl{Entry: entry}
+		d.runHttp(ctx, httpEntry)
+	} else if entry.Source.TibberApiSource != nil {
+		tibberApiEntry := config.TibberApiEntryImpl{Entry: entry}
+		d.runTibberApi(ctx, tibberApiEntry)
+	}
+}
 
-		if entry.HasFallback() {
-			d.startFallbackWatchdog(entry)
+// Stop cancels all pollers and watchdogs started by Run, waits for them to
+// return and unsubscribes the mqtt source topics. The state is saved last.
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	cancel := d.cancel
+	d.cancel = nil
+	d.running = make(map[string]*runningEntry)
+	d.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	d.wg.Wait()
+
+	if d.store != nil {
+		if err := d.saveState(); err != nil {
+			d.log.Error("Error saving state", "error", err)
+		} else {
+			d.log.Info("Saved state", "file", d.store.path)
 		}
+	}
+}
 
-		if entry.Source.MqttSource != nil {
-			mqttEntry := config.MqttEntryImpl{Entry: entry}
-			d.runMqtt(mqttEntry)
-		} else if entry.Source.HttpSource != nil {
-			httpEntry := config.HttpEntryImpl{Entry: entry}
-			d.runHttp(httpEntry)
-		} else if entry.Source.TibberApiSource != nil {
-			tibberApiEntry := config.TibberApiEntryImpl{Entry: entry}
-			d.runTibberApi(tibberApiEntry)
+// tickUntilDone runs tick immediately and then on every ticker tick until ctx is done.
+func tickUntilDone(ctx context.Context, ticker *time.Ticker, tick func()) {
+	tick() // First tick
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tick()
 		}
 	}
 }
@@ -95,99 +276,162 @@ var (
 	}
 )
 
-func (d *Dispatcher) runTibberApi(entry config.TibberApiEntry) {
-	d.log("Entry for " + entry.GetTypeName() + ": " + entry.GetName() + " with ID: " + entry.GetID())
+func (d *Dispatcher) runTibberApi(ctx context.Context, entry config.TibberApiEntry) {
+	log := d.entryLog(entry.GetEntry())
+	log.Info("Starting entry")
+	done := d.addWorker(ctx)
 	go func(e config.TibberApiEntry) {
+		defer done()
 		entry := e
 
 		ticker := getTicker(time.Duration(entry.GetTibberApiSource().IntervalSec) * time.Second)
 		defer ticker.Stop()
-		d.log("- Polling from tibber API with interval: " + time.Duration(entry.GetTibberApiSource().IntervalSec*int(time.Second)).String())
+		log.Info("Polling tibber api", "interval", time.Duration(entry.GetTibberApiSource().IntervalSec)*time.Second)
 
 		tickFunc := func(entry config.TibberApiEntry) {
 			payload, err := tibberapi.GetTibberAPIPayload(entry.GetTibberApiSource().TibberApiKey, entry.GetTibberApiSource().GraphqlQuery)
 			if err != nil {
-				d.log("Error getting HTTP payload: " + err.Error())
+				log.Error("Error getting tibber api payload", "error", err)
+				d.metrics.pollFailed(entry.GetName(), "tibber-api")
+				d.errors.poll.Add(1)
 				return
 			}
+			d.metrics.receivedPayload(entry.GetName())
 			for _, topicPub := range entry.GetTopicsToPublish() {
-				c := callbackConfig{Entry: e.GetEntry(), Id: entry.GetID(), PubTopic: topicPub.Topic, TransSource: entry.GetTibberApiSource(), TransTarget: topicPub, Filter: topicPub}
+				c := callbackConfig{Entry: e.GetEntry(), Id: entry.GetID(), PubTopic: topicPub.Topic, TransSource: entry.GetTibberApiSource(), TransTarget: topicPub, Filter: topicPub, Awtrix: topicPub.ResolvedAwtrix}
 				d.callback(payload, c, func(msg []byte) {
-					d.mqttClient.Publish(topicPub.Topic, msg)
+					d.publish(entry.GetEntry(), topicPub, msg)
 				})
 			}
 		}
 
-		tickFunc(entry) // First tick
-		for range ticker.C {
-			tickFunc(entry)
-			if interruptRunHttpTickerAfterTick.Load() {
-				return
-			}
-		}
-
+		tickUntilDone(ctx, ticker, func() { tickFunc(entry) })
 	}(entry)
 }
 
 // runHttp creates a trigger for the http source and attaches the callback
-func (d *Dispatcher) runHttp(entry config.HttpEntry) {
-	d.log("Entry for " + entry.GetTypeName() + ": " + entry.GetName() + " with ID: " + entry.GetID())
+func (d *Dispatcher) runHttp(ctx context.Context, entry config.HttpEntry) {
+	log := d.entryLog(entry.GetEntry())
+	log.Info("Starting entry")
 	for _, urlDef := range entry.GetSources() {
+		done := d.addWorker(ctx)
 		go func(e config.HttpEntry, u string) {
+			defer done()
 			tickerduration := time.Duration(time.Duration(entry.GetIntervalSec()) * time.Second)
 			ticker := getTicker(tickerduration)
 			defer ticker.Stop()
-			d.log("- Polling " + u + " with interval: " + tickerduration.String())
+			log.Info("Polling url", "url", u, "interval", tickerduration)
 
 			tickFunc := func(url string, entry config.HttpEntry) {
 				payload, err := httpsimple.GetHttpPayload(url)
 				if err != nil {
-					d.log("Error getting HTTP payload: " + err.Error())
+					log.Error("Error getting http payload", "url", url, "error", err)
+					d.metrics.pollFailed(entry.GetName(), "http")
+					d.errors.poll.Add(1)
 					return
 				}
+				d.metrics.receivedPayload(entry.GetName())
 				for _, topicPub := range entry.GetTopicsToPublish() {
-					c := callbackConfig{Entry: entry.GetEntry(), Id: url, PubTopic: topicPub.Topic, TransSource: urlDef, TransTarget: topicPub, Filter: topicPub}
+					c := callbackConfig{Entry: entry.GetEntry(), Id: url, PubTopic: topicPub.Topic, TransSource: urlDef, TransTarget: topicPub, Filter: topicPub, Awtrix: topicPub.ResolvedAwtrix}
 					d.callback(payload, c, func(msg []byte) {
-						d.mqttClient.Publish(topicPub.Topic, msg)
+						d.publish(entry.GetEntry(), topicPub, msg)
 					})
 				}
 			}
 
-			tickFunc(u, e) // First tick
-			for range ticker.C {
-				tickFunc(u, e)
-				if interruptRunHttpTickerAfterTick.Load() {
-					return
-				}
-			}
-
+			tickUntilDone(ctx, ticker, func() { tickFunc(u, e) })
 		}(entry, urlDef.Url)
 	}
 }
 
-// interruptRunHttpTickerAfterTick lets tests stop the ticker loops after one
-// tick. It is atomic because background goroutines may still read it while a
-// subsequent test writes it (e.g. under `go test -count`).
-var interruptRunHttpTickerAfterTick atomic.Bool
-
-// runMqtt creates a trigger for the mqtt source and attaches the callback
-func (d *Dispatcher) runMqtt(entry config.MqttEntry) {
-	d.log("Entry for " + entry.GetTypeName() + ": " + entry.GetName() + " with ID: " + entry.GetID())
+// runMqtt creates a trigger for the mqtt source and attaches the callback.
+// The subscriptions are removed again once ctx is done.
+func (d *Dispatcher) runMqtt(ctx context.Context, entry config.MqttEntry) {
+	log := d.entryLog(entry.GetEntry())
+	log.Info("Starting entry")
+	var removes []func()
 	for _, topicSub := range entry.GetTopicsToSubscribe() {
-		d.log("- Subscribing to " + topicSub.Topic)
-		err := d.mqttClient.Subscribe(topicSub.Topic, func(payload []byte) {
-			d.log("Received payload for " + topicSub.Topic)
+		log.Info("Subscribing", "topic", topicSub.Topic)
+		opts := SubscribeOptions{Qos: topicSub.ResolvedQos, NoLocal: topicSub.NoLocal}
+		remove, err := d.subscribe(topicSub.ResolvedBroker, topicSub.SubscribeFilter(), opts, func(topic string, payload []byte) {
+			log.Debug("Received payload", "topic", topic)
+			d.metrics.receivedPayload(entry.GetName())
+			// Each topic matched by a wildcard is a source of its own
+			captures, _ := config.MatchTopic(topicSub.Topic, topic)
 			for _, topicPub := range entry.GetTopicsToPublish() {
-				c := callbackConfig{Entry: entry.GetEntry(), Id: topicSub.Topic, PubTopic: topicPub.Topic, TransSource: topicSub, TransTarget: topicPub, Filter: topicPub}
+				fanOut := config.HasTopicPlaceholders(topicPub.Topic)
+				if fanOut {
+					topicPub.Topic = config.ExpandTopic(topicPub.Topic, captures)
+				}
+				c := callbackConfig{Entry: entry.GetEntry(), Id: topic, PubTopic: topicPub.Topic, TransSource: topicSub, TransTarget: topicPub, Filter: topicPub, Awtrix: topicPub.ResolvedAwtrix, FanOut: fanOut}
 				d.callback(payload, c, func(msg []byte) {
-					d.mqttClient.Publish(topicPub.Topic, msg)
+					d.publish(entry.GetEntry(), topicPub, msg)
 				})
 			}
 		})
 		if err != nil {
-			d.log("Error subscribing to topic: " + err.Error())
+			log.Error("Error subscribing to topic", "topic", topicSub.Topic, "error", err)
+			continue
+		}
+		removes = append(removes, remove)
+	}
+
+	done := d.addWorker(ctx)
+	go func() {
+		defer done()
+		<-ctx.Done()
+		for _, remove := range removes {
+			remove()
 		}
+	}()
+}
+
+// publish sends payload of an entry to the publish topic t. While the broker
+// connection is down the message is dropped instead of queued, the next value
+// replaces it anyway.
+func (d *Dispatcher) publish(entry config.Entry, t config.MqttTopicDefinition, payload []byte) {
+	entryName := entry.Name
+	topic := t.Topic
+	client := d.client(t.ResolvedBroker)
+	if client == nil {
+		d.log.Error("Unknown broker, dropping message", "entry", entryName, "topic", topic, "broker", t.ResolvedBroker)
+		return
 	}
+	if !client.IsConnected() {
+		d.log.Warn("Not connected to broker, dropping message", "entry", entryName, "topic", topic, "broker", t.ResolvedBroker)
+		return
+	}
+	opts := PublishOptions{
+		Qos:            t.ResolvedQos,
+		Retain:         t.ResolvedRetain,
+		MessageExpiry:  t.ResolvedMessageExpiry,
+		ContentType:    t.ContentType,
+		UserProperties: userProperties(entry, t),
+		ResponseTopic:  t.ResponseTopic,
+	}
+	if t.CorrelationData != "" {
+		opts.CorrelationData = []byte(t.CorrelationData)
+	}
+	if err := client.Publish(topic, payload, opts); err != nil {
+		d.errors.publish.Add(1)
+		return
+	}
+	d.metrics.published(entryName, topic)
+	d.recordPublished(entryName, topic, payload)
+	d.notifyPublished(entryName, topic, payload)
+}
+
+// userProperties returns the user properties of the publish topic t with the
+// id of the entry as "entry", so consumers can tell where a value came from.
+func userProperties(entry config.Entry, t config.MqttTopicDefinition) map[string]string {
+	props := make(map[string]string, len(t.UserProperties)+1)
+	for k, v := range t.UserProperties {
+		props[k] = v
+	}
+	if id, ok := entry.Identity(); ok {
+		props["entry"] = id.GetID()
+	}
+	return props
 }
 
 type callbackConfig struct {
@@ -197,43 +441,53 @@ type callbackConfig struct {
 	TransSource config.TransformSource
 	TransTarget config.TransformTarget
 	Filter      config.Filter
+	Awtrix      *config.AwtrixDefinition
+	// FanOut publishes the value of the source alone, to a publish topic with
+	// the wildcard segments of the source topic.
+	FanOut bool
 }
 
 var errorPayload = []byte(`{"text": "ERR"}`)
 
-func (d *Dispatcher) getOutputAsTibberGraph(payload []byte, c config.TransformSource) ([]byte, error) {
+// errJsonPath wraps the errors of a jsonPath that did not match the payload.
+var errJsonPath = errors.New("jsonPath lookup failed")
+
+func (d *Dispatcher) getOutputAsTibberGraph(log *slog.Logger, payload []byte, c config.TransformSource) ([]byte, error) {
 
 	payload = utils.TransformPayloadWithJsonPath(payload, c)
 
 	t := time.Now()
 	g, err := tibbergraph.CreateDraw(string(payload), t)
 	if err != nil {
-		d.log("Error creating graph: " + err.Error())
+		log.Error("Error creating graph", "error", err)
 		return nil, err
 	}
 	j, err := g.GetJson()
 	if err != nil {
-		d.log("Error getting json: " + err.Error())
+		log.Error("Error getting json", "error", err)
 		return nil, err
 	}
 
-	d.log("Generated TibberGraph with " + strconv.Itoa(len(g.Draw)) + " rows and with time: " + t.String())
+	log.Debug("Generated TibberGraph", "rows", len(g.Draw), "time", t)
 
 	return []byte(j), nil
 }
 
 // callback is called when a new event is received
 func (d *Dispatcher) callback(payload []byte, c callbackConfig, publish func([]byte)) {
+	log := d.entryLog(c.Entry).With("topic", c.PubTopic, "source", c.Id)
+
 	// Any received payload counts as activity for the no-value-read fallback,
 	// including the tibber-graph, filter, and transform-error paths below.
 	if c.Entry.HasFallback() {
 		d.markActivity(c.Entry.Name, c.PubTopic)
 	}
+	d.recordPayload(c.Entry.Name, c.Id, payload)
 
 	if c.TransTarget != nil && c.TransTarget.GetOutputAsTibberGraph() {
-		p, err := d.getOutputAsTibberGraph(payload, c.TransSource)
+		p, err := d.getOutputAsTibberGraph(log, payload, c.TransSource)
 		if err != nil {
-			d.log("Error getting TibberGraph: " + err.Error())
+			log.Error("Error getting TibberGraph", "error", err)
 			publish(errorPayload)
 			return
 		}
@@ -241,39 +495,73 @@ func (d *Dispatcher) callback(payload []byte, c callbackConfig, publish func([]b
 		return
 	}
 
+	if _, ok := textSource(c.TransSource); ok {
+		d.callbackText(log, payload, c, publish)
+		return
+	}
+
 	val, err := d.transformPayload(payload, c.TransSource)
 	if err != nil {
-		d.log("transform error: " + err.Error())
+		log.Warn("Transform error", "error", err, "payload", shortenPayload(payload))
+		d.metrics.transformFailed(c.Entry.Name, errors.Is(err, errJsonPath))
+		d.errors.transformFailed(errors.Is(err, errJsonPath))
 		return
 	}
 
 	// Accumulate. d.state is shared across the per-source goroutines (HTTP
 	// pollers, tibber poller, MQTT callbacks), so guard it with d.mu.
-	if must, op := c.Entry.MustAccumulate(); must {
+	if must, op := c.Entry.MustAccumulate(); must && !c.FanOut {
 		d.mu.Lock()
 		if _, ok := d.state[c.Entry.Name]; !ok {
 			d.state[c.Entry.Name] = make(map[string]float64)
 		}
+		if _, ok := d.updated[c.Entry.Name]; !ok {
+			d.updated[c.Entry.Name] = make(map[string]time.Time)
+		}
 		d.state[c.Entry.Name][c.Id] = val
-
-		if op == config.OperatorSum {
-			sum := 0.0
-			for _, v := range d.state[c.Entry.Name] {
-				sum += v
+		d.updated[c.Entry.Name][c.Id] = now()
+		sources, allFresh := d.freshValues(c.Entry)
+		values, firstReported := dispatcherState{c.Entry.Name: sources}.orderedValues(c.Entry)
+		d.mu.Unlock()
+
+		switch {
+		case !allFresh && c.Entry.SourceExpiry() == config.SourceExpiryWait:
+			log.Debug("Waiting for all sources to be fresh again")
+			return
+		case c.Entry.ValueScriptCallback != nil:
+			v, err := c.Entry.ValueScriptCallback(sources)
+			if err != nil {
+				log.Error("Value-script error", "error", err)
+				return
+			}
+			val = v
+			log.Debug("Value-script value", "value", val, "sources", len(sources))
+		case op == config.OperatorDiff && !firstReported:
+			log.Debug("Waiting for the first source before calculating the difference")
+			return
+		default:
+			acc, ok := op.Accumulate(values)
+			if !ok {
+				log.Error("Operation not supported", "operation", op)
+			} else {
+				val = acc
+				log.Debug("Accumulated value", "operation", op, "value", val, "sources", len(values))
 			}
-			val = sum
-			count := len(d.state[c.Entry.Name])
-			d.mu.Unlock()
-			d.log(fmt.Sprintf("Accumulated value for %s: %f, from %v values ", c.Entry.Name, val, count))
-		} else {
-			d.mu.Unlock()
-			d.log(fmt.Sprintf("Operation '%s' not supported", op))
 		}
 	}
 
 	// Track the resolved (possibly accumulated) value for no-value-change.
 	if c.Entry.HasFallback() {
-		d.markValue(c.Entry.Name, c.PubTopic, val)
+		d.markValue(c.Entry.Name, c.PubTopic, strconv.FormatFloat(val, 'g', -1, 64))
+	}
+	d.metrics.observeValue(c.Entry.Name, c.PubTopic, &val, now())
+	d.recordValue(c.Entry.Name, c.PubTopic, strconv.FormatFloat(val, 'g', -1, 64))
+
+	// Keep the history of the resolved value, also for filtered values.
+	var history []float64
+	chart := historyChart(c.TransTarget)
+	if chart != nil {
+		history = d.recordHistory(c, chart, val)
 	}
 
 	// Filter
@@ -287,7 +575,19 @@ func (d *Dispatcher) callback(payload []byte, c callbackConfig, publish func([]b
 		}
 	}
 
+	if chart != nil && chart.Style == config.HistoryChartDraw {
+		p, err := historyDrawPayload(history)
+		if err != nil {
+			log.Error("Error getting history chart", "error", err)
+			publish(errorPayload)
+			return
+		}
+		publish(p)
+		return
+	}
+
 	pubMsg := publishMessage{}
+	pubMsg.history = history
 
 	// Output Format
 	formatted := outputFormat(val, c.TransTarget)
@@ -300,20 +600,60 @@ func (d *Dispatcher) callback(payload []byte, c callbackConfig, publish func([]b
 		}
 	}
 
+	d.sendMessage(pubMsg, c, val, publish)
+}
+
+// sendMessage splits the text into the output-fragments of the publish topic,
+// adds the entry's icon and the awtrix options, computed from value, to pubMsg
+// and publishes it as json.
+func (d *Dispatcher) sendMessage(pubMsg publishMessage, c callbackConfig, value interface{}, publish func([]byte)) {
+	// Split into fragments
+	if fragments := c.TransTarget.GetOutputFragments(); len(fragments) > 0 {
+		pubMsg.Text = textFragments(pubMsg.Text.(string), pubMsg.Color, fragments)
+		pubMsg.Color = ""
+	}
+
 	// Add Icon
 	if c.Entry.Icon != "" {
 		pubMsg.Icon = c.Entry.Icon
 	}
 
+	// Add Awtrix options
+	awtrix, err := c.Awtrix.Resolve(value)
+	if err != nil {
+		d.entryLog(c.Entry).Warn("Error resolving awtrix options", "topic", c.PubTopic, "error", err)
+	}
+	if chart := historyChart(c.TransTarget); chart != nil {
+		awtrix = withHistoryChart(awtrix, chart, pubMsg.history)
+	}
+	pubMsg.AwtrixDefinition = awtrix
+
 	jsonData, err := json.Marshal(pubMsg)
 	if err != nil {
-		d.log(fmt.Sprintf("Error marshaling json: %v", err))
+		d.entryLog(c.Entry).Error("Error marshaling json", "topic", c.PubTopic, "error", err)
 		publish(errorPayload)
+		return
 	}
 
 	publish(jsonData)
 }
 
+// textFragments returns the fragments with the formatted value as text of the
+// value fragment. The color of the color-script wins over the fragment's color.
+func textFragments(text, color string, fragments []config.TextFragment) []config.TextFragment {
+	out := make([]config.TextFragment, len(fragments))
+	for i, f := range fragments {
+		out[i] = f
+		if f.Value {
+			out[i].Text = text
+			if color != "" {
+				out[i].Color = color
+			}
+		}
+	}
+	return out
+}
+
 func outputFormat(val float64, o config.TransformTarget) string {
 	if o.GetOutputFormat() != "" {
 		return fmt.Sprintf(o.GetOutputFormat(), val)
@@ -321,36 +661,37 @@ func outputFormat(val float64, o config.TransformTarget) string {
 	return fmt.Sprintf("%v", val)
 }
 
-func (d *Dispatcher) transformPayload(payload []byte, t config.TransformSource) (float64, error) {
-	jsonPath := t.GetJsonPath()
-	result := 0.0
-
-	var err error
+// extractValue returns the raw value of the payload, the whole payload without
+// jsonPath or else the value found at jsonPath.
+func (d *Dispatcher) extractValue(payload []byte, jsonPath string) (string, error) {
 	if jsonPath == "" {
 		trimmed := strings.TrimFunc(string(payload), func(r rune) bool {
 			return !unicode.IsPrint(r)
 		})
-		result, err = strconv.ParseFloat(trimmed, 64)
-		if err != nil {
-			return 0, err
-		}
-	} else {
-		var json_data interface{}
-		json.Unmarshal([]byte(payload), &json_data)
+		return trimmed, nil
+	}
 
-		var err error
-		var res interface{}
-		res, err = jsonpath.JsonPathLookup(json_data, jsonPath)
-		if err != nil {
-			d.log(fmt.Sprintf("transformPayload JsonPath error: %v input: %s jsonPath: %s", err, string(payload), jsonPath))
-			return 0, err
-		}
+	var json_data interface{}
+	json.Unmarshal([]byte(payload), &json_data)
 
-		result, err = strconv.ParseFloat(fmt.Sprintf("%v", res), 64)
-		if err != nil {
-			d.log(fmt.Sprintf("transformPayload ParseError: %v input: %s jsonPath: %s", err, string(payload), jsonPath))
-			return 0, err
-		}
+	res, err := jsonpath.JsonPathLookup(json_data, jsonPath)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v jsonPath: %s", errJsonPath, err, jsonPath)
+	}
+	return fmt.Sprintf("%v", res), nil
+}
+
+func (d *Dispatcher) transformPayload(payload []byte, t config.TransformSource) (float64, error) {
+	jsonPath := t.GetJsonPath()
+
+	raw, err := d.extractValue(payload, jsonPath)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, err
 	}
 
 	// Invert
@@ -379,7 +720,7 @@ func (d *Dispatcher) markActivity(entryName, pubTopic string) {
 
 // markValue records the resolved value for a publish topic. Used by the
 // no-value-change fallback; resets the fallback only when the value changes.
-func (d *Dispatcher) markValue(entryName, pubTopic string, val float64) {
+func (d *Dispatcher) markValue(entryName, pubTopic string, val string) {
 	if entryName == "" || pubTopic == "" {
 		return
 	}
@@ -422,7 +763,7 @@ func (d *Dispatcher) fireFallbacksIfStale(entry config.Entry) {
 	mode := entry.FallbackMode()
 	after := entry.FallbackAfter
 
-	var due []string
+	var due []config.MqttTopicDefinition
 	d.mu.Lock()
 	for _, pub := range entry.TopicsToPublish {
 		t := d.fallbacks[fallbackKey(entry.Name, pub.Topic)]
@@ -438,7 +779,7 @@ func (d *Dispatcher) fireFallbacksIfStale(entry config.Entry) {
 		}
 		if stale {
 			t.fired = true
-			due = append(due, pub.Topic)
+			due = append(due, pub)
 		}
 	}
 	d.mu.Unlock()
@@ -448,15 +789,16 @@ func (d *Dispatcher) fireFallbacksIfStale(entry config.Entry) {
 		return
 	}
 	payload := d.fallbackPayload(entry)
-	for _, topic := range due {
-		d.log("Fallback firing for " + entry.Name + " -> " + topic)
-		d.mqttClient.Publish(topic, payload)
+	for _, pub := range due {
+		d.entryLog(entry).Info("Fallback firing", "topic", pub.Topic)
+		d.metrics.fallbackFired(entry.Name, pub.Topic)
+		d.publish(entry, pub, payload)
 	}
 }
 
 // startFallbackWatchdog seeds tracking state and starts a goroutine that
 // periodically publishes the configured fallback once a topic goes stale.
-func (d *Dispatcher) startFallbackWatchdog(entry config.Entry) {
+func (d *Dispatcher) startFallbackWatchdog(ctx context.Context, entry config.Entry) {
 	d.seedFallback(entry)
 
 	interval := entry.FallbackAfter / 4
@@ -464,19 +806,15 @@ func (d *Dispatcher) startFallbackWatchdog(entry config.Entry) {
 		interval = time.Second
 	}
 
-	d.log(fmt.Sprintf("- Fallback watchdog for %s: mode=%s after=%s", entry.Name, entry.FallbackMode(), entry.FallbackAfter))
+	d.entryLog(entry).Info("Fallback watchdog started", "mode", entry.FallbackMode(), "after", entry.FallbackAfter)
 
+	done := d.addWorker(ctx)
 	go func(entry config.Entry) {
+		defer done()
 		ticker := getTicker(interval)
 		defer ticker.Stop()
 
-		d.fireFallbacksIfStale(entry)
-		for range ticker.C {
-			d.fireFallbacksIfStale(entry)
-			if interruptRunHttpTickerAfterTick.Load() {
-				return
-			}
-		}
+		tickUntilDone(ctx, ticker, func() { d.fireFallbacksIfStale(entry) })
 	}(entry)
 }
 
@@ -491,7 +829,7 @@ func (d *Dispatcher) fallbackPayload(entry config.Entry) []byte {
 	}
 	b, err := json.Marshal(msg)
 	if err != nil {
-		d.log(fmt.Sprintf("Error marshaling fallback json: %v", err))
+		d.entryLog(entry).Error("Error marshaling fallback json", "error", err)
 		return errorPayload
 	}
 	return b
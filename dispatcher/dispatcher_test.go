@@ -1,6 +1,7 @@
 package dispatcher
 
 import (
+	"context"
 	"errors"
 	"go-mqtt-dispatcher/config"
 	httpsimple "go-mqtt-dispatcher/dispatcher/httpsimple"
@@ -40,25 +41,24 @@ func TestRunHttp(t *testing.T) {
 	}
 
 	mqttClient := NewMockMqttClient()
-	log := func(s string) {
-		t.Log(s)
-	}
 
-	dispatcher, err := NewDispatcher(&[]config.Entry{entry}, mqttClient, log)
+	dispatcher, err := NewDispatcher(&[]config.Entry{entry}, mqttClient, newTestLogger(t))
 	if err != nil {
 		t.Fatalf("Failed to create dispatcher: %v", err)
 	}
 
 	// Run the dispatcher
-	interruptRunHttpTickerAfterTick.Store(true)
 	getTicker = func(_ time.Duration) *time.Ticker {
 		return time.NewTicker(1 * time.Millisecond)
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	httpEntry := config.HttpEntryImpl{Entry: entry}
-	go dispatcher.runHttp(httpEntry)
+	dispatcher.runHttp(ctx, httpEntry)
 
 	// Wait for the ticker to tick
 	time.Sleep(10 * time.Millisecond)
+	cancel()
+	dispatcher.wg.Wait()
 
 	// Check if the message was published
 	if msg, ok := mqttClient.GetPublishedMessage("test/topic"); !ok {
@@ -93,14 +93,14 @@ func TestRunMqtt(t *testing.T) {
 
 	mqttClient := NewMockMqttClient(log)
 
-	dispatcher, err := NewDispatcher(&[]config.Entry{entry}, mqttClient, log)
+	dispatcher, err := NewDispatcher(&[]config.Entry{entry}, mqttClient, newTestLogger(t))
 	if err != nil {
 		t.Fatalf("Failed to create dispatcher: %v", err)
 	}
 
 	// Run the dispatcher
 	mqttEntry := config.MqttEntryImpl{Entry: entry}
-	dispatcher.runMqtt(mqttEntry)
+	dispatcher.runMqtt(context.Background(), mqttEntry)
 
 	// Check if the subscription was made
 	if !mqttClient.IsSubscribed("test/subscribe") {
@@ -222,7 +222,7 @@ func TestCallback(t *testing.T) {
 
 			mqttClient := NewMockMqttClient(log)
 
-			dispatcher, err := NewDispatcher(&[]config.Entry{tt.entry}, mqttClient, log)
+			dispatcher, err := NewDispatcher(&[]config.Entry{tt.entry}, mqttClient, newTestLogger(t))
 			if err != nil {
 				t.Fatalf("Failed to create dispatcher: %v", err)
 			}
@@ -233,7 +233,7 @@ func TestCallback(t *testing.T) {
 
 			// Simulate receiving a message
 			dispatcher.callback(tt.payload, tt.config, func(msg []byte) {
-				mqttClient.Publish("test/publish", msg)
+				mqttClient.Publish("test/publish", msg, PublishOptions{})
 			})
 
 			// Check if the message was published
@@ -247,3 +247,299 @@ func TestCallback(t *testing.T) {
 		})
 	}
 }
+
+func TestPublishDroppedWhileDisconnected(t *testing.T) {
+	entry := config.Entry{
+		Name: "testEntry",
+		Source: config.EntrySource{
+			MqttSource: &config.MqttSource{
+				TopicsToSubscribe: []config.MqttTopicDefinition{
+					{Topic: "test/subscribe", Transform: config.TransformDefinition{JsonPath: "$.value"}},
+				},
+			},
+		},
+		TopicsToPublish: []config.MqttTopicDefinition{
+			{Topic: "test/publish"},
+		},
+	}
+
+	log := func(s string) {
+		t.Log(s)
+	}
+
+	mqttClient := NewMockMqttClient(log)
+	dispatcher, err := NewDispatcher(&[]config.Entry{entry}, mqttClient, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("Failed to create dispatcher: %v", err)
+	}
+	dispatcher.runMqtt(context.Background(), config.MqttEntryImpl{Entry: entry})
+
+	mqttClient.SetConnected(false)
+	mqttClient.SimulateMessage("test/subscribe", []byte(`{"value": 1}`))
+	if count := mqttClient.GetPublishCount("test/publish"); count != 0 {
+		t.Errorf("Expected no publish while disconnected, got %d", count)
+	}
+
+	mqttClient.SetConnected(true)
+	mqttClient.SimulateMessage("test/subscribe", []byte(`{"value": 2}`))
+	if msg, _ := mqttClient.GetPublishedMessage("test/publish"); string(msg) != `{"text":"2"}` {
+		t.Errorf("Expected message %s, but got %s", `{"text":"2"}`, string(msg))
+	}
+}
+
+func TestRunAndStop(t *testing.T) {
+	httpsimple.HttpGetOverrideForTesting = func(url string) (resp *http.Response, err error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`7`)),
+		}, nil
+	}
+	getTicker = func(_ time.Duration) *time.Ticker {
+		return time.NewTicker(1 * time.Millisecond)
+	}
+
+	entries := []config.Entry{
+		{
+			Name: "httpEntry",
+			Source: config.EntrySource{
+				HttpSource: &config.HttpSource{
+					Urls:        []config.HttpUrlDefinition{{Url: "http://example.com"}},
+					IntervalSec: 1,
+				},
+			},
+			TopicsToPublish: []config.MqttTopicDefinition{{Topic: "test/http"}},
+		},
+		{
+			Name: "mqttEntry",
+			Source: config.EntrySource{
+				MqttSource: &config.MqttSource{
+					TopicsToSubscribe: []config.MqttTopicDefinition{{Topic: "test/subscribe"}},
+				},
+			},
+			TopicsToPublish: []config.MqttTopicDefinition{{Topic: "test/mqtt"}},
+		},
+	}
+
+	log := func(s string) {
+		t.Log(s)
+	}
+	mqttClient := NewMockMqttClient(log)
+	dispatcher, err := NewDispatcher(&entries, mqttClient, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("Failed to create dispatcher: %v", err)
+	}
+
+	dispatcher.Run(context.Background())
+	time.Sleep(10 * time.Millisecond)
+	if !mqttClient.IsSubscribed("test/subscribe") {
+		t.Errorf("Expected subscription to test/subscribe")
+	}
+
+	// Stop returns only after the poller exited and the topics are unsubscribed.
+	dispatcher.Stop()
+	if mqttClient.IsSubscribed("test/subscribe") {
+		t.Errorf("Expected test/subscribe to be unsubscribed after Stop")
+	}
+	count := mqttClient.GetPublishCount("test/http")
+	if count == 0 {
+		t.Errorf("Expected the http poller to publish before Stop")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if after := mqttClient.GetPublishCount("test/http"); after != count {
+		t.Errorf("Expected no publish after Stop, got %d more", after-count)
+	}
+}
+
+func newAccumulateEntry(op string) config.Entry {
+	return config.Entry{
+		Name:      "accEntry",
+		Operation: op,
+		Source: config.EntrySource{
+			MqttSource: &config.MqttSource{
+				TopicsToSubscribe: []config.MqttTopicDefinition{
+					{Topic: "grid"},
+					{Topic: "solar"},
+					{Topic: "battery"},
+				},
+			},
+		},
+		TopicsToPublish: []config.MqttTopicDefinition{{Topic: "house"}},
+	}
+}
+
+func TestCallbackOperators(t *testing.T) {
+	tests := []struct {
+		op       string
+		expected string
+	}{
+		{"sum", `{"text":"1200"}`},
+		{"avg", `{"text":"400"}`},
+		{"min", `{"text":"100"}`},
+		{"max", `{"text":"800"}`},
+		{"count", `{"text":"3"}`},
+		{"product", `{"text":"2.4e+07"}`},
+		{"diff", `{"text":"400"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.op, func(t *testing.T) {
+			log := func(s string) {
+				t.Log(s)
+			}
+			mqttClient := NewMockMqttClient(log)
+			entry := newAccumulateEntry(tt.op)
+			dispatcher, err := NewDispatcher(&[]config.Entry{entry}, mqttClient, newTestLogger(t))
+			if err != nil {
+				t.Fatalf("Failed to create dispatcher: %v", err)
+			}
+			dispatcher.runMqtt(context.Background(), config.MqttEntryImpl{Entry: entry})
+
+			// Reported out of configured order, diff still subtracts from "grid".
+			mqttClient.SimulateMessage("battery", []byte(`100`))
+			mqttClient.SimulateMessage("solar", []byte(`300`))
+			mqttClient.SimulateMessage("grid", []byte(`800`))
+
+			if msg := lastMessage(mqttClient, "house"); msg != tt.expected {
+				t.Errorf("Expected message %s, but got %s", tt.expected, msg)
+			}
+		})
+	}
+}
+
+func TestCallbackDiffWaitsForFirstSource(t *testing.T) {
+	log := func(s string) {
+		t.Log(s)
+	}
+	mqttClient := NewMockMqttClient(log)
+	entry := newAccumulateEntry("diff")
+	dispatcher, err := NewDispatcher(&[]config.Entry{entry}, mqttClient, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("Failed to create dispatcher: %v", err)
+	}
+	dispatcher.runMqtt(context.Background(), config.MqttEntryImpl{Entry: entry})
+
+	mqttClient.SimulateMessage("solar", []byte(`300`))
+	if count := mqttClient.GetPublishCount("house"); count != 0 {
+		t.Errorf("Expected no publish before the first source reported, got %d", count)
+	}
+
+	mqttClient.SimulateMessage("grid", []byte(`800`))
+	if msg := lastMessage(mqttClient, "house"); msg != `{"text":"500"}` {
+		t.Errorf("Expected message %s, but got %s", `{"text":"500"}`, msg)
+	}
+}
+
+func TestCallbackValueScript(t *testing.T) {
+	log := func(s string) {
+		t.Log(s)
+	}
+	mqttClient := NewMockMqttClient(log)
+	entry := newAccumulateEntry("")
+	entry.ValueScriptCallback = func(values map[string]float64) (float64, error) {
+		return values["grid"] + values["solar"] - values["battery"], nil
+	}
+	entry.ValueScript = "set"
+	dispatcher, err := NewDispatcher(&[]config.Entry{entry}, mqttClient, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("Failed to create dispatcher: %v", err)
+	}
+	dispatcher.runMqtt(context.Background(), config.MqttEntryImpl{Entry: entry})
+
+	mqttClient.SimulateMessage("grid", []byte(`400`))
+	mqttClient.SimulateMessage("solar", []byte(`300`))
+	mqttClient.SimulateMessage("battery", []byte(`100`))
+
+	if msg := lastMessage(mqttClient, "house"); msg != `{"text":"600"}` {
+		t.Errorf("Expected message %s, but got %s", `{"text":"600"}`, msg)
+	}
+}
+
+func TestCallbackAwtrixOptions(t *testing.T) {
+	progress := 0
+	awtrix := &config.AwtrixDefinition{
+		Duration: &progress,
+		ScriptCallbacks: map[string]func(interface{}) (interface{}, error){
+			"progress": func(v interface{}) (interface{}, error) { return int(v.(float64) / 10), nil },
+		},
+	}
+	log := func(s string) {
+		t.Log(s)
+	}
+	mqttClient := NewMockMqttClient(log)
+	dispatcher, err := NewDispatcher(&[]config.Entry{}, mqttClient, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("Failed to create dispatcher: %v", err)
+	}
+
+	c := callbackConfig{
+		Entry:       config.Entry{Name: "testEntry", Icon: "sun"},
+		Id:          "test/subscribe",
+		PubTopic:    "test/publish",
+		TransSource: config.MqttTopicDefinition{},
+		TransTarget: config.MqttTopicDefinition{},
+		Filter:      config.MqttTopicDefinition{},
+		Awtrix:      awtrix,
+	}
+	dispatcher.callback([]byte(`420`), c, func(msg []byte) {
+		mqttClient.Publish("test/publish", msg, PublishOptions{})
+	})
+
+	expected := `{"text":"420","icon":"sun","duration":0,"progress":42}`
+	if msg := lastMessage(mqttClient, "test/publish"); msg != expected {
+		t.Errorf("Expected message %s, but got %s", expected, msg)
+	}
+}
+
+func TestCallbackOutputFragments(t *testing.T) {
+	log := func(s string) {
+		t.Log(s)
+	}
+	mqttClient := NewMockMqttClient(log)
+	dispatcher, err := NewDispatcher(&[]config.Entry{}, mqttClient, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("Failed to create dispatcher: %v", err)
+	}
+
+	target := config.MqttTopicDefinition{Transform: config.TransformDefinition{
+		OutputFormat: "%0.0f",
+		OutputFragments: []config.TextFragment{
+			{Value: true, Color: "#FFFFFF"},
+			{Text: " W", Color: "#FFFFFF"},
+		},
+	}}
+	tests := []struct {
+		name        string
+		colorScript func(float64) (string, error)
+		expected    string
+	}{
+		{
+			name:        "ColorScript",
+			colorScript: func(v float64) (string, error) { return "#00FF00", nil },
+			expected:    `{"text":[{"t":"392","c":"#00FF00"},{"t":" W","c":"#FFFFFF"}]}`,
+		},
+		{
+			name:     "FragmentColor",
+			expected: `{"text":[{"t":"392","c":"#FFFFFF"},{"t":" W","c":"#FFFFFF"}]}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := callbackConfig{
+				Entry:       config.Entry{Name: "testEntry", ColorScriptCallback: tc.colorScript},
+				Id:          "test/subscribe",
+				PubTopic:    "test/publish",
+				TransSource: config.MqttTopicDefinition{},
+				TransTarget: target,
+				Filter:      config.MqttTopicDefinition{},
+			}
+			dispatcher.callback([]byte(`392.4`), c, func(msg []byte) {
+				mqttClient.Publish("test/publish", msg, PublishOptions{})
+			})
+
+			if msg := lastMessage(mqttClient, "test/publish"); msg != tc.expected {
+				t.Errorf("Expected message %s, but got %s", tc.expected, msg)
+			}
+		})
+	}
+}
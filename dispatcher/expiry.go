@@ -0,0 +1,31 @@
+package dispatcher
+
+import "go-mqtt-dispatcher/config"
+
+// freshValues returns the source values of the entry for accumulation with
+// source-max-age applied: expired sources are dropped or set to 0, and with
+// source-expiry wait allFresh is false while any source is expired. A source
+// is logged once when it expires. Callers hold d.mu.
+func (d *Dispatcher) freshValues(entry config.Entry) (values map[string]float64, allFresh bool) {
+	values = make(map[string]float64, len(d.state[entry.Name]))
+	allFresh = true
+	n := now()
+	for id, v := range d.state[entry.Name] {
+		key := fallbackKey(entry.Name, id)
+		if entry.SourceMaxAgeParsed == 0 || n.Sub(d.updated[entry.Name][id]) <= entry.SourceMaxAgeParsed {
+			delete(d.expired, key)
+			values[id] = v
+			continue
+		}
+
+		if !d.expired[key] {
+			d.expired[key] = true
+			d.entryLog(entry).Info("Source expired", "source", id, "last_value", v, "max_age", entry.SourceMaxAgeParsed)
+		}
+		allFresh = false
+		if entry.SourceExpiry() == config.SourceExpiryZero {
+			values[id] = 0
+		}
+	}
+	return values, allFresh
+}
@@ -0,0 +1,60 @@
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"go-mqtt-dispatcher/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallbackSourceMaxAge(t *testing.T) {
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	origNow := now
+	now = func() time.Time { return clock }
+	defer func() { now = origNow }()
+
+	tests := []struct {
+		expiry   string
+		expected string
+	}{
+		{"drop", `{"text":"450"}`},
+		{"zero", `{"text":"300"}`},
+		{"wait", `{"text":"400"}`}, // last message before solar expired
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expiry, func(t *testing.T) {
+			var logs bytes.Buffer
+			mqttClient := NewMockMqttClient()
+			entry := newAccumulateEntry("avg")
+			entry.SourceMaxAge = "5m"
+			entry.SourceMaxAgeParsed = 5 * time.Minute
+			entry.SourceExpiryMode = tt.expiry
+			dispatcher, err := NewDispatcher(&[]config.Entry{entry}, mqttClient, newBufferLogger(&logs))
+			if err != nil {
+				t.Fatalf("Failed to create dispatcher: %v", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			dispatcher.runMqtt(ctx, config.MqttEntryImpl{Entry: entry})
+
+			mqttClient.SimulateMessage("solar", []byte(`300`))
+			clock = clock.Add(4 * time.Minute)
+			mqttClient.SimulateMessage("battery", []byte(`100`))
+			mqttClient.SimulateMessage("grid", []byte(`800`))
+			clock = clock.Add(2 * time.Minute)
+			mqttClient.SimulateMessage("grid", []byte(`800`))
+			mqttClient.SimulateMessage("grid", []byte(`800`))
+
+			if msg := lastMessage(mqttClient, "house"); msg != tt.expected {
+				t.Errorf("Expected message %s, but got %s", tt.expected, msg)
+			}
+			expiredLogs := strings.Count(logs.String(), `msg="Source expired" entry=accEntry`)
+			if expiredLogs != 1 {
+				t.Errorf("Expected the expired source to be logged once, got %d", expiredLogs)
+			}
+		})
+	}
+}
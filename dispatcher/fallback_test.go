@@ -1,6 +1,7 @@
 package dispatcher
 
 import (
+	"context"
 	"go-mqtt-dispatcher/config"
 	"sync/atomic"
 	"testing"
@@ -56,12 +57,12 @@ func setup(t *testing.T, entry config.Entry) (*Dispatcher, *MockMqttClient) {
 	t.Helper()
 	log := func(s string) { t.Log(s) }
 	mc := NewMockMqttClient(log)
-	d, err := NewDispatcher(&[]config.Entry{entry}, mc, log)
+	d, err := NewDispatcher(&[]config.Entry{entry}, mc, newTestLogger(t))
 	require.NoError(t, err)
 	if entry.HasFallback() {
 		d.seedFallback(entry)
 	}
-	d.runMqtt(config.MqttEntryImpl{Entry: entry})
+	d.runMqtt(context.Background(), config.MqttEntryImpl{Entry: entry})
 	return d, mc
 }
 
@@ -0,0 +1,106 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+)
+
+// errorCounters counts the failures of all entries since the start, for the
+// heartbeat.
+type errorCounters struct {
+	transform atomic.Int64
+	jsonPath  atomic.Int64
+	poll      atomic.Int64
+	publish   atomic.Int64
+}
+
+// transformFailed counts a failed transform as jsonPath failure or as transform error.
+func (c *errorCounters) transformFailed(jsonPath bool) {
+	if jsonPath {
+		c.jsonPath.Add(1)
+	} else {
+		c.transform.Add(1)
+	}
+}
+
+// HeartbeatErrors are the error counters of a heartbeat.
+type HeartbeatErrors struct {
+	Transform int64 `json:"transform"`
+	JsonPath  int64 `json:"jsonpath"`
+	Poll      int64 `json:"poll"`
+	Publish   int64 `json:"publish"`
+}
+
+// Heartbeat is the json payload published to the heartbeat topic.
+type Heartbeat struct {
+	Version   string          `json:"version"`
+	Started   time.Time       `json:"started"`
+	UptimeSec int64           `json:"uptime-sec"`
+	Entries   int             `json:"entries"`
+	Disabled  int             `json:"disabled"`
+	Errors    HeartbeatErrors `json:"errors"`
+}
+
+// WithHeartbeat publishes a Heartbeat to topic every interval while running.
+func WithHeartbeat(topic string, interval time.Duration, version string) Option {
+	return func(d *Dispatcher) {
+		d.heartbeatTopic = topic
+		d.heartbeatInterval = interval
+		d.version = version
+	}
+}
+
+// Heartbeat returns the current heartbeat.
+func (d *Dispatcher) Heartbeat() Heartbeat {
+	d.mu.Lock()
+	entries := *d.entries
+	d.mu.Unlock()
+
+	h := Heartbeat{
+		Version:   d.version,
+		Started:   d.started,
+		UptimeSec: int64(now().Sub(d.started) / time.Second),
+		Errors: HeartbeatErrors{
+			Transform: d.errors.transform.Load(),
+			JsonPath:  d.errors.jsonPath.Load(),
+			Poll:      d.errors.poll.Load(),
+			Publish:   d.errors.publish.Load(),
+		},
+	}
+	for _, e := range entries {
+		if e.Disabled {
+			h.Disabled++
+		} else {
+			h.Entries++
+		}
+	}
+	return h
+}
+
+// runHeartbeat publishes the heartbeat at once and every heartbeatInterval
+// until ctx is done.
+func (d *Dispatcher) runHeartbeat(ctx context.Context) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := getTicker(d.heartbeatInterval)
+		defer ticker.Stop()
+		tickUntilDone(ctx, ticker, d.publishHeartbeat)
+	}()
+}
+
+func (d *Dispatcher) publishHeartbeat() {
+	if !d.mqttClient.IsConnected() {
+		return
+	}
+	payload, err := json.Marshal(d.Heartbeat())
+	if err != nil {
+		d.log.Error("Error encoding heartbeat", "error", err)
+		return
+	}
+	if err := d.mqttClient.Publish(d.heartbeatTopic, payload, PublishOptions{Retain: true}); err != nil {
+		d.log.Error("Error publishing heartbeat", "topic", d.heartbeatTopic, "error", err)
+	}
+}
@@ -0,0 +1,52 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"go-mqtt-dispatcher/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeat(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+	defer func() { now = func() time.Time { return time.Now() } }()
+
+	mc := NewMockMqttClient(func(s string) { t.Log(s) })
+	entry := newMqttEntry("power", "sub/power", "pub/power")
+	entry.Source.MqttSource.TopicsToSubscribe[0].Transform.JsonPath = "$.power"
+	disabled := newMqttEntry("solar", "sub/solar", "pub/solar")
+	disabled.Disabled = true
+
+	d, err := NewDispatcher(&[]config.Entry{entry, disabled}, mc, newTestLogger(t), WithHeartbeat("dispatcher/status/heartbeat", time.Hour, "1.2.3"))
+	require.NoError(t, err)
+	d.Run(context.Background())
+	defer d.Stop()
+
+	// The first heartbeat is published at once.
+	assert.Eventually(t, func() bool {
+		_, ok := mc.GetPublishedMessage("dispatcher/status/heartbeat")
+		return ok
+	}, time.Second, time.Millisecond)
+
+	mc.SimulateMessage("sub/power", []byte(`{"other":1}`))
+	mc.SimulateMessage("sub/power", []byte(`not json`))
+	now = func() time.Time { return start.Add(90 * time.Second) }
+	d.publishHeartbeat()
+
+	msg, _ := mc.GetPublishedMessage("dispatcher/status/heartbeat")
+	var h Heartbeat
+	require.NoError(t, json.Unmarshal(msg, &h))
+	assert.Equal(t, Heartbeat{
+		Version:   "1.2.3",
+		Started:   start,
+		UptimeSec: 90,
+		Entries:   1,
+		Disabled:  1,
+		Errors:    HeartbeatErrors{JsonPath: 2},
+	}, h)
+}
@@ -0,0 +1,77 @@
+package dispatcher
+
+import (
+	"encoding/json"
+	"go-mqtt-dispatcher/config"
+	tibbergraph "go-mqtt-dispatcher/tibber-graph"
+	"math"
+	"time"
+)
+
+// historySample is one resolved value of an entry's rolling history.
+type historySample struct {
+	at    time.Time
+	value float64
+}
+
+// historyChart returns the history chart of the publish target, if any.
+func historyChart(t config.TransformTarget) *config.HistoryChartDefinition {
+	if t == nil {
+		return nil
+	}
+	return t.GetOutputAsHistoryChart()
+}
+
+// recordHistory appends val to the history of the publish topic and returns
+// the values still within the chart's limits, oldest first.
+func (d *Dispatcher) recordHistory(c callbackConfig, chart *config.HistoryChartDefinition, val float64) []float64 {
+	key := fallbackKey(c.Entry.Name, c.PubTopic)
+	t := now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	samples := append(d.history[key], historySample{at: t, value: val})
+	if chart.MaxAge > 0 {
+		i := 0
+		for i < len(samples) && t.Sub(samples[i].at) > chart.MaxAge {
+			i++
+		}
+		samples = samples[i:]
+	}
+	if max := chart.MaxSamples(); len(samples) > max {
+		samples = samples[len(samples)-max:]
+	}
+	// Copy so the backing array does not grow without bound.
+	d.history[key] = append([]historySample(nil), samples...)
+
+	values := make([]float64, len(samples))
+	for i, s := range samples {
+		values[i] = s.value
+	}
+	return values
+}
+
+// historyDrawPayload returns the history as Awtrix draw commands.
+func historyDrawPayload(values []float64) ([]byte, error) {
+	g := tibbergraph.CreateHistoryDraw(values)
+	return json.Marshal(g)
+}
+
+// withHistoryChart returns a copy of awtrix with the history set as bar or line.
+func withHistoryChart(awtrix *config.AwtrixDefinition, chart *config.HistoryChartDefinition, values []float64) *config.AwtrixDefinition {
+	var a config.AwtrixDefinition
+	if awtrix != nil {
+		a = *awtrix
+	}
+	ints := make([]int, len(values))
+	for i, v := range values {
+		ints[i] = int(math.Round(v))
+	}
+	if chart.Style == config.HistoryChartLine {
+		a.Line = ints
+	} else {
+		a.Bar = ints
+	}
+	return &a
+}
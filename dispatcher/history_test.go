@@ -0,0 +1,78 @@
+package dispatcher
+
+import (
+	"go-mqtt-dispatcher/config"
+	"testing"
+	"time"
+)
+
+func TestCallbackHistoryChart(t *testing.T) {
+	log := func(s string) {
+		t.Log(s)
+	}
+
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	origNow := now
+	now = func() time.Time { return clock }
+	defer func() { now = origNow }()
+
+	tests := []struct {
+		name     string
+		chart    config.HistoryChartDefinition
+		values   []string
+		step     time.Duration
+		expected string
+	}{
+		{
+			name:     "BarSamples",
+			chart:    config.HistoryChartDefinition{Style: config.HistoryChartBar, Samples: 3},
+			values:   []string{"1", "2", "3", "4.4"},
+			step:     time.Second,
+			expected: `{"text":"4.4","bar":[2,3,4]}`,
+		},
+		{
+			name:     "LineDuration",
+			chart:    config.HistoryChartDefinition{Style: config.HistoryChartLine, MaxAge: 90 * time.Second},
+			values:   []string{"1", "2", "3"},
+			step:     time.Minute,
+			expected: `{"text":"3","line":[2,3]}`,
+		},
+		{
+			name:     "Draw",
+			chart:    config.HistoryChartDefinition{Style: config.HistoryChartDraw},
+			values:   []string{"0", "100"},
+			step:     time.Second,
+			expected: `{"draw":[{"dp":[30,7,"#00FF00"]},{"dp":[31,0,"#0000FF"]}]}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mqttClient := NewMockMqttClient(log)
+			dispatcher, err := NewDispatcher(&[]config.Entry{}, mqttClient, newTestLogger(t))
+			if err != nil {
+				t.Fatalf("Failed to create dispatcher: %v", err)
+			}
+
+			chart := tc.chart
+			c := callbackConfig{
+				Entry:       config.Entry{Name: "testEntry"},
+				Id:          "test/subscribe",
+				PubTopic:    "test/publish",
+				TransSource: config.MqttTopicDefinition{},
+				TransTarget: config.MqttTopicDefinition{Transform: config.TransformDefinition{OutputAsHistoryChart: &chart}},
+				Filter:      config.MqttTopicDefinition{},
+			}
+			for _, v := range tc.values {
+				clock = clock.Add(tc.step)
+				dispatcher.callback([]byte(v), c, func(msg []byte) {
+					mqttClient.Publish("test/publish", msg, PublishOptions{})
+				})
+			}
+
+			if msg := lastMessage(mqttClient, "test/publish"); msg != tc.expected {
+				t.Errorf("Expected message %s, but got %s", tc.expected, msg)
+			}
+		})
+	}
+}
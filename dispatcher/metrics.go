@@ -0,0 +1,117 @@
+package dispatcher
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the prometheus metrics of the dispatcher, labeled by entry name.
+// A nil *Metrics is valid and records nothing.
+type Metrics struct {
+	received        *prometheus.CounterVec
+	publishes       *prometheus.CounterVec
+	transformErrors *prometheus.CounterVec
+	jsonPathErrors  *prometheus.CounterVec
+	pollErrors      *prometheus.CounterVec
+	fallbacks       *prometheus.CounterVec
+	lastValue       *prometheus.GaugeVec
+	lastUpdate      *prometheus.GaugeVec
+}
+
+const metricsNamespace = "mqtt_dispatcher"
+
+// NewMetrics creates the dispatcher metrics and registers them with reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: metricsNamespace, Name: name, Help: help}, labels)
+	}
+	gauge := func(name, help string, labels ...string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: metricsNamespace, Name: name, Help: help}, labels)
+	}
+
+	m := &Metrics{
+		received:        counter("received_payloads_total", "Payloads received from the sources of an entry.", "entry"),
+		publishes:       counter("publishes_total", "Messages published to a topic of an entry.", "entry", "topic"),
+		transformErrors: counter("transform_errors_total", "Payloads of an entry whose value could not be parsed.", "entry"),
+		jsonPathErrors:  counter("jsonpath_failures_total", "Payloads of an entry whose jsonPath did not match.", "entry"),
+		pollErrors:      counter("poll_failures_total", "Failed http and tibber api polls of an entry.", "entry", "source"),
+		fallbacks:       counter("fallback_firings_total", "Fallbacks published to a topic of an entry.", "entry", "topic"),
+		lastValue:       gauge("last_value", "Last resolved numeric value published to a topic of an entry.", "entry", "topic"),
+		lastUpdate:      gauge("last_update_timestamp_seconds", "Unix time of the last resolved value of a topic of an entry.", "entry", "topic"),
+	}
+	reg.MustRegister(m.received, m.publishes, m.transformErrors, m.jsonPathErrors, m.pollErrors, m.fallbacks, m.lastValue, m.lastUpdate)
+	return m
+}
+
+// WithMetrics records the dispatcher metrics in m.
+func WithMetrics(m *Metrics) Option {
+	return func(d *Dispatcher) {
+		d.metrics = m
+	}
+}
+
+func (m *Metrics) receivedPayload(entry string) {
+	if m == nil {
+		return
+	}
+	m.received.WithLabelValues(entry).Inc()
+}
+
+func (m *Metrics) published(entry, topic string) {
+	if m == nil {
+		return
+	}
+	m.publishes.WithLabelValues(entry, topic).Inc()
+}
+
+// transformFailed counts a failed transform as jsonPath failure or as transform error.
+func (m *Metrics) transformFailed(entry string, jsonPath bool) {
+	if m == nil {
+		return
+	}
+	if jsonPath {
+		m.jsonPathErrors.WithLabelValues(entry).Inc()
+	} else {
+		m.transformErrors.WithLabelValues(entry).Inc()
+	}
+}
+
+func (m *Metrics) pollFailed(entry, source string) {
+	if m == nil {
+		return
+	}
+	m.pollErrors.WithLabelValues(entry, source).Inc()
+}
+
+func (m *Metrics) fallbackFired(entry, topic string) {
+	if m == nil {
+		return
+	}
+	m.fallbacks.WithLabelValues(entry, topic).Inc()
+}
+
+// observeValue sets the last value, if numeric, and the last update time.
+func (m *Metrics) observeValue(entry, topic string, value *float64, at time.Time) {
+	if m == nil {
+		return
+	}
+	if value != nil {
+		m.lastValue.WithLabelValues(entry, topic).Set(*value)
+	}
+	m.lastUpdate.WithLabelValues(entry, topic).Set(float64(at.UnixNano()) / 1e9)
+}
+
+// deleteEntry drops all series of a stopped entry.
+func (m *Metrics) deleteEntry(entry string) {
+	if m == nil {
+		return
+	}
+	labels := prometheus.Labels{"entry": entry}
+	for _, v := range []*prometheus.MetricVec{
+		m.received.MetricVec, m.publishes.MetricVec, m.transformErrors.MetricVec, m.jsonPathErrors.MetricVec,
+		m.pollErrors.MetricVec, m.fallbacks.MetricVec, m.lastValue.MetricVec, m.lastUpdate.MetricVec,
+	} {
+		v.DeletePartialMatch(labels)
+	}
+}
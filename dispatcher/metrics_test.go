@@ -0,0 +1,73 @@
+package dispatcher
+
+import (
+	"context"
+	"go-mqtt-dispatcher/config"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	log := func(s string) {
+		t.Log(s)
+	}
+
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	origNow := now
+	now = func() time.Time { return clock }
+	defer func() { now = origNow }()
+
+	reg := prometheus.NewRegistry()
+	metrics := NewMetrics(reg)
+	mqttClient := NewMockMqttClient(log)
+	entry := config.Entry{
+		Name: "power",
+		Source: config.EntrySource{MqttSource: &config.MqttSource{
+			TopicsToSubscribe: []config.MqttTopicDefinition{{Topic: "meter", Transform: config.TransformDefinition{JsonPath: "$.power"}}},
+		}},
+		TopicsToPublish: []config.MqttTopicDefinition{{Topic: "awtrix/power"}},
+		Fallback:        &config.FallbackDefinition{Mode: "no-value-read", After: "1m", Value: "?", Color: "#888888"},
+		FallbackAfter:   time.Minute,
+	}
+	d, err := NewDispatcher(&[]config.Entry{entry}, mqttClient, newTestLogger(t), WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("Failed to create dispatcher: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.seedFallback(entry)
+	d.runMqtt(ctx, config.MqttEntryImpl{Entry: entry})
+
+	mqttClient.SimulateMessage("meter", []byte(`{"power": 392}`))
+	mqttClient.SimulateMessage("meter", []byte(`{"energy": 1}`))
+	mqttClient.SimulateMessage("meter", []byte(`{"power": "n/a"}`))
+	clock = clock.Add(2 * time.Minute)
+	d.fireFallbacksIfStale(entry)
+
+	checks := []struct {
+		name     string
+		metric   prometheus.Collector
+		expected float64
+	}{
+		{"received", metrics.received.WithLabelValues("power"), 3},
+		{"publishes", metrics.publishes.WithLabelValues("power", "awtrix/power"), 2},
+		{"jsonpath", metrics.jsonPathErrors.WithLabelValues("power"), 1},
+		{"transform", metrics.transformErrors.WithLabelValues("power"), 1},
+		{"fallback", metrics.fallbacks.WithLabelValues("power", "awtrix/power"), 1},
+		{"last value", metrics.lastValue.WithLabelValues("power", "awtrix/power"), 392},
+		{"last update", metrics.lastUpdate.WithLabelValues("power", "awtrix/power"), float64(clock.Add(-2 * time.Minute).Unix())},
+	}
+	for _, c := range checks {
+		if got := testutil.ToFloat64(c.metric); got != c.expected {
+			t.Errorf("Expected %s %v, got %v", c.name, c.expected, got)
+		}
+	}
+
+	metrics.deleteEntry("power")
+	if n := testutil.CollectAndCount(metrics.received); n != 0 {
+		t.Errorf("Expected no series after deleteEntry, got %d", n)
+	}
+}
@@ -0,0 +1,253 @@
+package dispatcher
+
+import (
+	"context"
+	"go-mqtt-dispatcher/config"
+	"go-mqtt-dispatcher/logging"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// connectTimeout limits how long Connect waits for the first connection.
+const connectTimeout = 30 * time.Second
+
+// connectionManager is the part of autopaho's ConnectionManager used by Mqtt5Client.
+type connectionManager interface {
+	Publish(ctx context.Context, p *paho.Publish) (*paho.PublishResponse, error)
+	Subscribe(ctx context.Context, s *paho.Subscribe) (*paho.Suback, error)
+	Unsubscribe(ctx context.Context, u *paho.Unsubscribe) (*paho.Unsuback, error)
+	AwaitConnection(ctx context.Context) error
+	Disconnect(ctx context.Context) error
+}
+
+// newConnection starts autopaho's connection manager, replaced in tests.
+var newConnection = func(ctx context.Context, cfg autopaho.ClientConfig) (connectionManager, error) {
+	return autopaho.NewConnection(ctx, cfg)
+}
+
+// Mqtt5Client implements MqttClient with MQTT 5 on top of autopaho, so
+// published messages can carry message expiry, content type and user
+// properties, and subscriptions can use no-local.
+type Mqtt5Client struct {
+	cfg       autopaho.ClientConfig
+	conn      connectionManager
+	log       *slog.Logger
+	connected atomic.Bool
+
+	// mu guards subscriptions, connectedOnce and statusTopic. The session ends
+	// with the connection, so all subscriptions are re-issued after a reconnect.
+	mu            sync.Mutex
+	subscriptions map[string]subscription5
+	connectedOnce bool
+
+	// statusTopic receives StatusOnline after every connect (optional).
+	statusTopic string
+}
+
+type subscription5 struct {
+	opts     SubscribeOptions
+	callback func(topic string, payload []byte)
+}
+
+// NewMqtt5Client creates the client from cfg, autopaho reconnects with an
+// exponential backoff. Call Connect afterwards.
+func NewMqtt5Client(cfg autopaho.ClientConfig, log *slog.Logger) *Mqtt5Client {
+	if log == nil {
+		log = logging.Discard()
+	}
+	c := &Mqtt5Client{log: log, subscriptions: make(map[string]subscription5)}
+
+	cfg.ReconnectBackoff = autopaho.NewExponentialBackoff(time.Second, maxReconnectInterval, 2*time.Second, 2)
+	cfg.OnConnectionUp = func(cm *autopaho.ConnectionManager, _ *paho.Connack) {
+		// must not block, autopaho waits for it before handling packets
+		c.connected.Store(true)
+		go c.onConnectionUp(cm)
+	}
+	cfg.OnConnectionDown = func() bool {
+		c.connected.Store(false)
+		c.log.Warn("Connection to MQTT broker lost")
+		return true
+	}
+	cfg.OnConnectError = func(err error) {
+		c.log.Warn("Connecting to MQTT broker failed", "error", err)
+	}
+	cfg.OnPublishReceived = append(cfg.OnPublishReceived, c.onPublishReceived)
+	c.cfg = cfg
+	return c
+}
+
+// SetStatusTopic registers StatusOffline as retained last will on topic and
+// makes the client publish StatusOnline to topic after every connect and
+// StatusOffline on Disconnect. Call it before Connect.
+func (c *Mqtt5Client) SetStatusTopic(topic string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statusTopic = topic
+	c.cfg.WillMessage = &paho.WillMessage{Topic: topic, Payload: []byte(StatusOffline), QoS: 1, Retain: true}
+}
+
+// Connect starts the connection and blocks until the first connection is up.
+func (c *Mqtt5Client) Connect() error {
+	conn, err := newConnection(context.Background(), c.cfg)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err := conn.AwaitConnection(ctx); err != nil {
+		conn.Disconnect(context.Background())
+		return err
+	}
+	return nil
+}
+
+func (c *Mqtt5Client) IsConnected() bool {
+	return c.connected.Load()
+}
+
+func (c *Mqtt5Client) Publish(topic string, payload []byte, opts PublishOptions) error {
+	c.log.Debug("Publishing", "topic", topic, "qos", opts.Qos, "retain", opts.Retain, "payload", shortenPayload(payload))
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	_, err := c.conn.Publish(ctx, publishPacket(topic, payload, opts))
+	if err != nil {
+		c.log.Error("Error publishing message", "topic", topic, "error", err)
+	}
+	return err
+}
+
+// publishPacket builds the MQTT 5 publish packet with the properties of opts.
+func publishPacket(topic string, payload []byte, opts PublishOptions) *paho.Publish {
+	props := &paho.PublishProperties{
+		ContentType:     opts.ContentType,
+		ResponseTopic:   opts.ResponseTopic,
+		CorrelationData: opts.CorrelationData,
+	}
+	if opts.MessageExpiry > 0 {
+		expiry := uint32(opts.MessageExpiry / time.Second)
+		props.MessageExpiry = &expiry
+	}
+	// sorted for a stable packet, the broker keeps the order
+	for _, k := range slices.Sorted(maps.Keys(opts.UserProperties)) {
+		props.User.Add(k, opts.UserProperties[k])
+	}
+	return &paho.Publish{QoS: opts.Qos, Retain: opts.Retain, Topic: topic, Properties: props, Payload: payload}
+}
+
+func (c *Mqtt5Client) Subscribe(topic string, opts SubscribeOptions, callback func(topic string, payload []byte)) error {
+	c.mu.Lock()
+	c.subscriptions[topic] = subscription5{opts: opts, callback: callback}
+	c.mu.Unlock()
+
+	return c.subscribe(c.conn, topic, opts)
+}
+
+func (c *Mqtt5Client) subscribe(conn connectionManager, topic string, opts SubscribeOptions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	_, err := conn.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: opts.Qos, NoLocal: opts.NoLocal}},
+	})
+	return err
+}
+
+func (c *Mqtt5Client) Unsubscribe(topics ...string) error {
+	c.mu.Lock()
+	for _, topic := range topics {
+		delete(c.subscriptions, topic)
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	_, err := c.conn.Unsubscribe(ctx, &paho.Unsubscribe{Topics: topics})
+	return err
+}
+
+// Disconnect closes the broker connection. A graceful disconnect doesn't
+// trigger the last will, so the status topic gets StatusOffline first.
+func (c *Mqtt5Client) Disconnect() {
+	c.mu.Lock()
+	statusTopic := c.statusTopic
+	c.mu.Unlock()
+	if statusTopic != "" && c.IsConnected() {
+		c.publishStatus(statusTopic, StatusOffline)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err := c.conn.Disconnect(ctx); err != nil {
+		c.log.Error("Error disconnecting from MQTT broker", "error", err)
+	}
+	c.connected.Store(false)
+	c.log.Info("Disconnected from MQTT broker")
+}
+
+// publishStatus publishes the retained status payload to topic.
+func (c *Mqtt5Client) publishStatus(topic, status string) {
+	if err := c.Publish(topic, []byte(status), PublishOptions{Qos: 1, Retain: true}); err == nil {
+		c.log.Info("Published status", "topic", topic, "status", status)
+	}
+}
+
+// onConnectionUp publishes the online status and re-issues all registered
+// subscriptions on conn, after the initial connect and after every reconnect.
+func (c *Mqtt5Client) onConnectionUp(conn connectionManager) {
+	c.mu.Lock()
+	statusTopic := c.statusTopic
+	c.mu.Unlock()
+	if statusTopic != "" {
+		// conn may not be stored in c yet on the initial connect
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		_, err := conn.Publish(ctx, publishPacket(statusTopic, []byte(StatusOnline), PublishOptions{Qos: 1, Retain: true}))
+		cancel()
+		if err != nil {
+			c.log.Error("Error publishing message", "topic", statusTopic, "error", err)
+		} else {
+			c.log.Info("Published status", "topic", statusTopic, "status", StatusOnline)
+		}
+	}
+
+	c.mu.Lock()
+	if !c.connectedOnce {
+		c.connectedOnce = true
+		c.mu.Unlock()
+		c.log.Info("Connected to MQTT broker", "protocol", "MQTT 5")
+		return
+	}
+	subs := maps.Clone(c.subscriptions)
+	c.mu.Unlock()
+
+	c.log.Info("Reconnected to MQTT broker, resubscribing", "topics", len(subs))
+	for topic, sub := range subs {
+		if err := c.subscribe(conn, topic, sub.opts); err != nil {
+			c.log.Error("Error resubscribing", "topic", topic, "error", err)
+		}
+	}
+}
+
+// onPublishReceived hands a received message to the callback of every
+// subscription matching its topic.
+func (c *Mqtt5Client) onPublishReceived(pr paho.PublishReceived) (bool, error) {
+	c.mu.Lock()
+	var callbacks []func(string, []byte)
+	for filter, sub := range c.subscriptions {
+		if _, ok := config.MatchTopic(filter, pr.Packet.Topic); ok {
+			callbacks = append(callbacks, sub.callback)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(pr.Packet.Topic, pr.Packet.Payload)
+	}
+	return len(callbacks) > 0, nil
+}
@@ -0,0 +1,152 @@
+package dispatcher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConnection records the packets sent through autopaho's connection manager.
+type fakeConnection struct {
+	mu           sync.Mutex
+	published    []*paho.Publish
+	subscribed   []paho.SubscribeOptions
+	unsubscribed []string
+	disconnected bool
+}
+
+func (f *fakeConnection) Publish(ctx context.Context, p *paho.Publish) (*paho.PublishResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = append(f.published, p)
+	return &paho.PublishResponse{}, nil
+}
+
+func (f *fakeConnection) Subscribe(ctx context.Context, s *paho.Subscribe) (*paho.Suback, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subscribed = append(f.subscribed, s.Subscriptions...)
+	return &paho.Suback{}, nil
+}
+
+func (f *fakeConnection) Unsubscribe(ctx context.Context, u *paho.Unsubscribe) (*paho.Unsuback, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unsubscribed = append(f.unsubscribed, u.Topics...)
+	return &paho.Unsuback{}, nil
+}
+
+func (f *fakeConnection) AwaitConnection(ctx context.Context) error { return nil }
+
+func (f *fakeConnection) Disconnect(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.disconnected = true
+	return nil
+}
+
+func newFakeMqtt5Client(t *testing.T) (*Mqtt5Client, *fakeConnection) {
+	fake := &fakeConnection{}
+	orig := newConnection
+	newConnection = func(ctx context.Context, cfg autopaho.ClientConfig) (connectionManager, error) {
+		return fake, nil
+	}
+	t.Cleanup(func() { newConnection = orig })
+
+	c := NewMqtt5Client(autopaho.ClientConfig{}, nil)
+	require.NoError(t, c.Connect())
+	return c, fake
+}
+
+func TestMqtt5ClientPublishProperties(t *testing.T) {
+	c, fake := newFakeMqtt5Client(t)
+
+	require.NoError(t, c.Publish("awtrix/custom/power", []byte(`{"text":"5 W"}`), PublishOptions{
+		Qos:             1,
+		Retain:          true,
+		MessageExpiry:   5 * time.Minute,
+		ContentType:     "application/json",
+		UserProperties:  map[string]string{"unit": "W", "entry": "2JQ6YIL5"},
+		ResponseTopic:   "awtrix/custom/power/reply",
+		CorrelationData: []byte("power"),
+	}))
+	require.NoError(t, c.Publish("awtrix/custom/plain", []byte(`x`), PublishOptions{}))
+
+	require.Len(t, fake.published, 2)
+	p := fake.published[0]
+	assert.Equal(t, "awtrix/custom/power", p.Topic)
+	assert.Equal(t, byte(1), p.QoS)
+	assert.True(t, p.Retain)
+	require.NotNil(t, p.Properties.MessageExpiry)
+	assert.Equal(t, uint32(300), *p.Properties.MessageExpiry)
+	assert.Equal(t, "application/json", p.Properties.ContentType)
+	assert.Equal(t, paho.UserProperties{{Key: "entry", Value: "2JQ6YIL5"}, {Key: "unit", Value: "W"}}, p.Properties.User)
+	assert.Equal(t, "awtrix/custom/power/reply", p.Properties.ResponseTopic)
+	assert.Equal(t, []byte("power"), p.Properties.CorrelationData)
+
+	assert.Nil(t, fake.published[1].Properties.MessageExpiry)
+	assert.Empty(t, fake.published[1].Properties.User)
+	assert.Nil(t, fake.published[1].Properties.CorrelationData)
+}
+
+func TestMqtt5ClientSubscriptions(t *testing.T) {
+	c, fake := newFakeMqtt5Client(t)
+	c.onConnectionUp(fake)
+
+	var received []string
+	require.NoError(t, c.Subscribe("$share/group/power/+", SubscribeOptions{Qos: 1}, func(topic string, payload []byte) {
+		received = append(received, topic+"="+string(payload))
+	}))
+	require.NoError(t, c.Subscribe("solar", SubscribeOptions{NoLocal: true}, func(string, []byte) {}))
+	assert.Equal(t, []paho.SubscribeOptions{
+		{Topic: "$share/group/power/+", QoS: 1},
+		{Topic: "solar", NoLocal: true},
+	}, fake.subscribed)
+
+	handled, err := c.onPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Topic: "power/kitchen", Payload: []byte(`5`)}})
+	require.NoError(t, err)
+	assert.True(t, handled)
+	assert.Equal(t, []string{"power/kitchen=5"}, received)
+
+	// A reconnect re-issues every registered subscription.
+	require.NoError(t, c.Unsubscribe("solar"))
+	fake.subscribed = nil
+	c.onConnectionUp(fake)
+	assert.Equal(t, []paho.SubscribeOptions{{Topic: "$share/group/power/+", QoS: 1}}, fake.subscribed)
+	assert.Equal(t, []string{"solar"}, fake.unsubscribed)
+}
+
+func TestMqtt5ClientStatusTopic(t *testing.T) {
+	fake := &fakeConnection{}
+	orig := newConnection
+	var will *paho.WillMessage
+	newConnection = func(ctx context.Context, cfg autopaho.ClientConfig) (connectionManager, error) {
+		will = cfg.WillMessage
+		return fake, nil
+	}
+	defer func() { newConnection = orig }()
+
+	c := NewMqtt5Client(autopaho.ClientConfig{}, nil)
+	c.SetStatusTopic("dispatcher/status")
+	require.NoError(t, c.Connect())
+	assert.Equal(t, &paho.WillMessage{Topic: "dispatcher/status", Payload: []byte(StatusOffline), QoS: 1, Retain: true}, will)
+
+	c.connected.Store(true)
+	c.onConnectionUp(fake)
+	c.Disconnect()
+
+	var status []string
+	for _, p := range fake.published {
+		assert.True(t, p.Retain)
+		status = append(status, p.Topic+"="+string(p.Payload))
+	}
+	assert.Equal(t, []string{"dispatcher/status=online", "dispatcher/status=offline"}, status)
+	assert.True(t, fake.disconnected)
+	assert.False(t, c.IsConnected())
+}
@@ -2,44 +2,233 @@ package dispatcher
 
 import (
 	"bytes"
-	"log"
+	"fmt"
+	"go-mqtt-dispatcher/logging"
+	"log/slog"
+	"sync"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 type MqttClient interface {
-	Publish(topic string, payload []byte) error
-	Subscribe(topic string, callback func([]byte)) error
+	Publish(topic string, payload []byte, opts PublishOptions) error
+	// Subscribe replaces the callback and options of an existing subscription
+	// of topic. topic may contain wildcards, callback gets the matched topic.
+	// The subscription is kept and re-issued after a reconnect even if it
+	// fails, remove it with Unsubscribe.
+	Subscribe(topic string, opts SubscribeOptions, callback func(topic string, payload []byte)) error
+	Unsubscribe(topics ...string) error
+	IsConnected() bool
 }
 
+// PublishOptions are the mqtt options of a published message. All but Qos and
+// Retain are MQTT 5 properties, ignored by MQTT 3.1.1 clients.
+type PublishOptions struct {
+	Qos             byte
+	Retain          bool
+	MessageExpiry   time.Duration // 0 for no expiry
+	ContentType     string
+	UserProperties  map[string]string
+	ResponseTopic   string
+	CorrelationData []byte
+}
+
+// SubscribeOptions are the mqtt options of a subscription. NoLocal is an MQTT 5
+// option, ignored by MQTT 3.1.1 clients.
+type SubscribeOptions struct {
+	Qos     byte
+	NoLocal bool
+}
+
+// The payloads of the status topic. StatusOffline is also the last will, which
+// the broker publishes when the connection breaks.
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+const (
+	// maxReconnectInterval caps the exponential backoff of paho's auto reconnect.
+	maxReconnectInterval = 1 * time.Minute
+	// disconnectQuiesce is the time in milliseconds paho waits for in-flight work on Disconnect.
+	disconnectQuiesce = 250
+)
+
+// requestTimeout limits how long a publish, subscribe, unsubscribe or
+// disconnect waits for the broker, e.g. for the PUBACK of a qos 1 publish while
+// the connection is down. A var for tests.
+var requestTimeout = 10 * time.Second
+
 type PahoMqttClient struct {
 	client mqtt.Client
+	log    *slog.Logger
+
+	// mu guards subscriptions and connectedOnce, which are read by the
+	// OnConnect handler to re-issue all subscriptions after a reconnect.
+	mu            sync.Mutex
+	subscriptions map[string]subscription
+	connectedOnce bool
+
+	// statusTopic receives StatusOnline after every connect (optional).
+	statusTopic string
 }
 
-func NewPahoMqttClient(client mqtt.Client) *PahoMqttClient {
-	return &PahoMqttClient{client: client}
+type subscription struct {
+	qos     byte
+	handler mqtt.MessageHandler
 }
 
-func (c *PahoMqttClient) Publish(topic string, payload []byte) error {
-	log.Printf("Publishing to '%s': '%s'\n", topic, shortenPayload(payload))
-	token := c.client.Publish(topic, 0, true, payload)
-	token.Wait()
-	err := token.Error()
+// NewPahoMqttClient creates the paho client from opts with auto reconnect enabled.
+// Every subscription made through Subscribe is re-issued after a reconnect.
+// The callbacks run in their own goroutines, as they publish themselves and a
+// qos 1 or 2 publish waits for an ack paho's reading goroutine has to receive.
+// Call Connect afterwards.
+func NewPahoMqttClient(opts *mqtt.ClientOptions, log *slog.Logger) *PahoMqttClient {
+	if log == nil {
+		log = logging.Discard()
+	}
+	c := &PahoMqttClient{log: log, subscriptions: make(map[string]subscription)}
+
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(maxReconnectInterval)
+	opts.SetOnConnectHandler(c.onConnect)
+	opts.SetConnectionLostHandler(c.onConnectionLost)
+	opts.SetReconnectingHandler(c.onReconnecting)
+	opts.SetOrderMatters(false)
+
+	c.client = mqtt.NewClient(opts)
+	return c
+}
+
+// SetStatusWill registers StatusOffline as retained last will on topic in opts.
+// Use it together with SetStatusTopic.
+func SetStatusWill(opts *mqtt.ClientOptions, topic string) {
+	opts.SetWill(topic, StatusOffline, 1, true)
+}
+
+// SetStatusTopic makes the client publish StatusOnline to topic after every
+// connect and StatusOffline on Disconnect. Call it before Connect.
+func (c *PahoMqttClient) SetStatusTopic(topic string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statusTopic = topic
+}
+
+// Connect connects to the broker and blocks until the first connection attempt finished.
+func (c *PahoMqttClient) Connect() error {
+	token := c.client.Connect()
+	for !token.WaitTimeout(3 * time.Second) {
+	}
+	return token.Error()
+}
+
+func (c *PahoMqttClient) IsConnected() bool {
+	return c.client.IsConnectionOpen()
+}
+
+func (c *PahoMqttClient) Publish(topic string, payload []byte, opts PublishOptions) error {
+	c.log.Debug("Publishing", "topic", topic, "qos", opts.Qos, "retain", opts.Retain, "payload", shortenPayload(payload))
+	err := waitToken(c.client.Publish(topic, opts.Qos, opts.Retain, payload), "publish")
 	if err != nil {
-		log.Printf("Error publishing message: %v", err)
+		c.log.Error("Error publishing message", "topic", topic, "error", err)
 	}
 	return err
 }
 
-func (c *PahoMqttClient) Subscribe(topic string, callback func([]byte)) error {
+func (c *PahoMqttClient) Subscribe(topic string, opts SubscribeOptions, callback func(topic string, payload []byte)) error {
+	qos := opts.Qos
 	handler := func(client mqtt.Client, msg mqtt.Message) {
-		callback(msg.Payload())
+		callback(msg.Topic(), msg.Payload())
+	}
+
+	c.mu.Lock()
+	c.subscriptions[topic] = subscription{qos: qos, handler: handler}
+	c.mu.Unlock()
+
+	return waitToken(c.client.Subscribe(topic, qos, handler), "subscribe")
+}
+
+func (c *PahoMqttClient) Unsubscribe(topics ...string) error {
+	c.mu.Lock()
+	for _, topic := range topics {
+		delete(c.subscriptions, topic)
+	}
+	c.mu.Unlock()
+
+	return waitToken(c.client.Unsubscribe(topics...), "unsubscribe")
+}
+
+// waitToken waits up to requestTimeout for the broker to complete the request of token.
+func waitToken(token mqtt.Token, request string) error {
+	if !token.WaitTimeout(requestTimeout) {
+		return fmt.Errorf("%s timed out after %s", request, requestTimeout)
 	}
-	token := c.client.Subscribe(topic, 0, handler)
-	token.Wait()
 	return token.Error()
 }
 
+// Disconnect closes the broker connection after in-flight messages are sent.
+// A graceful disconnect doesn't trigger the last will, so the status topic gets
+// StatusOffline first.
+func (c *PahoMqttClient) Disconnect() {
+	c.mu.Lock()
+	statusTopic := c.statusTopic
+	c.mu.Unlock()
+	if statusTopic != "" && c.IsConnected() {
+		c.publishStatus(statusTopic, StatusOffline)
+	}
+	c.client.Disconnect(disconnectQuiesce)
+	c.log.Info("Disconnected from MQTT broker")
+}
+
+// publishStatus publishes the retained status payload to topic.
+func (c *PahoMqttClient) publishStatus(topic, status string) {
+	if err := c.Publish(topic, []byte(status), PublishOptions{Qos: 1, Retain: true}); err == nil {
+		c.log.Info("Published status", "topic", topic, "status", status)
+	}
+}
+
+// onConnect publishes the online status and re-issues all registered
+// subscriptions. It is called by paho in its own goroutine after the initial
+// connect and after every reconnect, the last will may have replaced the status
+// in between.
+func (c *PahoMqttClient) onConnect(client mqtt.Client) {
+	c.mu.Lock()
+	statusTopic := c.statusTopic
+	c.mu.Unlock()
+	if statusTopic != "" {
+		c.publishStatus(statusTopic, StatusOnline)
+	}
+
+	c.mu.Lock()
+	if !c.connectedOnce {
+		c.connectedOnce = true
+		c.mu.Unlock()
+		c.log.Info("Connected to MQTT broker")
+		return
+	}
+	subs := make(map[string]subscription, len(c.subscriptions))
+	for topic, sub := range c.subscriptions {
+		subs[topic] = sub
+	}
+	c.mu.Unlock()
+
+	c.log.Info("Reconnected to MQTT broker, resubscribing", "topics", len(subs))
+	for topic, sub := range subs {
+		if err := waitToken(client.Subscribe(topic, sub.qos, sub.handler), "subscribe"); err != nil {
+			c.log.Error("Error resubscribing", "topic", topic, "error", err)
+		}
+	}
+}
+
+func (c *PahoMqttClient) onConnectionLost(client mqtt.Client, err error) {
+	c.log.Warn("Connection to MQTT broker lost", "error", err)
+}
+
+func (c *PahoMqttClient) onReconnecting(client mqtt.Client, opts *mqtt.ClientOptions) {
+	c.log.Info("Reconnecting to MQTT broker")
+}
+
 // shortenPayload returns a shortened version without linebreaks of the payload for logging purposes
 func shortenPayload(payload []byte) string {
 	// Remove line breaks
@@ -0,0 +1,167 @@
+package dispatcher
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePahoClient records subscriptions and publishes made on the underlying paho client.
+// Methods not overridden panic through the nil embedded interface.
+type fakePahoClient struct {
+	mqtt.Client
+	mu         sync.Mutex
+	subscribed []string
+	published  []string
+	handlers   map[string]mqtt.MessageHandler
+	// unacked makes qos 1 and 2 publishes wait for an ack that never comes
+	unacked bool
+	// unackedRequests does the same for subscribes and unsubscribes
+	unackedRequests bool
+}
+
+func (f *fakePahoClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = append(f.published, topic+"="+string(payload.([]byte)))
+	if f.unacked && qos > 0 {
+		return pendingToken{}
+	}
+	return &mqtt.DummyToken{}
+}
+
+// pendingToken never completes.
+type pendingToken struct{}
+
+func (pendingToken) Wait() bool                     { select {} }
+func (pendingToken) WaitTimeout(time.Duration) bool { return false }
+func (pendingToken) Done() <-chan struct{}          { return nil }
+func (pendingToken) Error() error                   { return nil }
+
+func (f *fakePahoClient) IsConnectionOpen() bool { return true }
+
+func (f *fakePahoClient) Disconnect(quiesce uint) {}
+
+func (f *fakePahoClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subscribed = append(f.subscribed, topic)
+	if f.handlers == nil {
+		f.handlers = make(map[string]mqtt.MessageHandler)
+	}
+	f.handlers[topic] = callback
+	if f.unackedRequests {
+		return pendingToken{}
+	}
+	return &mqtt.DummyToken{}
+}
+
+func (f *fakePahoClient) Unsubscribe(topics ...string) mqtt.Token {
+	if f.unackedRequests {
+		return pendingToken{}
+	}
+	return &mqtt.DummyToken{}
+}
+
+func (f *fakePahoClient) subscriptions() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.subscribed...)
+}
+
+func TestPahoMqttClientResubscribesOnReconnect(t *testing.T) {
+	fake := &fakePahoClient{}
+	c := NewPahoMqttClient(mqtt.NewClientOptions(), nil)
+	c.client = fake
+
+	// Initial connect happens before any subscription.
+	c.onConnect(fake)
+	assert.Empty(t, fake.subscriptions())
+
+	assert.NoError(t, c.Subscribe("a/topic", SubscribeOptions{}, func(string, []byte) {}))
+	assert.NoError(t, c.Subscribe("b/topic", SubscribeOptions{}, func(string, []byte) {}))
+	assert.ElementsMatch(t, []string{"a/topic", "b/topic"}, fake.subscriptions())
+
+	// A reconnect re-issues every registered subscription.
+	c.onConnect(fake)
+	assert.ElementsMatch(t, []string{"a/topic", "b/topic", "a/topic", "b/topic"}, fake.subscriptions())
+}
+
+func TestPahoMqttClientStatusTopic(t *testing.T) {
+	opts := mqtt.NewClientOptions()
+	SetStatusWill(opts, "dispatcher/status")
+	assert.Equal(t, "dispatcher/status", opts.WillTopic)
+	assert.Equal(t, []byte(StatusOffline), opts.WillPayload)
+	assert.True(t, opts.WillRetained)
+
+	fake := &fakePahoClient{}
+	c := NewPahoMqttClient(opts, nil)
+	c.client = fake
+	c.SetStatusTopic("dispatcher/status")
+
+	// Every connect publishes online again, the last will may have replaced it.
+	c.onConnect(fake)
+	c.onConnect(fake)
+	c.Disconnect()
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Equal(t, []string{"dispatcher/status=online", "dispatcher/status=online", "dispatcher/status=offline"}, fake.published)
+}
+
+// fakeMessage is a received message for the handlers of fakePahoClient.
+type fakeMessage struct {
+	mqtt.Message
+	topic   string
+	payload []byte
+}
+
+func (m fakeMessage) Topic() string   { return m.topic }
+func (m fakeMessage) Payload() []byte { return m.payload }
+
+func TestPahoMqttClientPublishFromCallback(t *testing.T) {
+	opts := mqtt.NewClientOptions()
+	c := NewPahoMqttClient(opts, nil)
+	// paho runs the callbacks outside its reading goroutine, which has to
+	// receive the ack of a qos 1 publish made by a callback
+	assert.False(t, opts.Order)
+
+	timeout := requestTimeout
+	defer func() { requestTimeout = timeout }()
+	requestTimeout = 10 * time.Millisecond
+
+	fake := &fakePahoClient{unacked: true}
+	c.client = fake
+	var err error
+	require.NoError(t, c.Subscribe("power", SubscribeOptions{Qos: 1}, func(topic string, payload []byte) {
+		err = c.Publish("awtrix/power", payload, PublishOptions{Qos: 1})
+	}))
+
+	// Without the ack the publish gives up instead of blocking the callback
+	fake.handlers["power"](fake, fakeMessage{topic: "power", payload: []byte(`5`)})
+	assert.ErrorContains(t, err, "publish timed out")
+	assert.Equal(t, []string{"awtrix/power=5"}, fake.published)
+}
+
+func TestPahoMqttClientRequestTimeouts(t *testing.T) {
+	timeout := requestTimeout
+	defer func() { requestTimeout = timeout }()
+	requestTimeout = 10 * time.Millisecond
+
+	fake := &fakePahoClient{}
+	c := NewPahoMqttClient(mqtt.NewClientOptions(), nil)
+	c.client = fake
+	c.onConnect(fake)
+	require.NoError(t, c.Subscribe("a/topic", SubscribeOptions{}, func(string, []byte) {}))
+
+	// A half-open connection must not block a reload or the shutdown
+	fake.unackedRequests = true
+	assert.ErrorContains(t, c.Subscribe("b/topic", SubscribeOptions{}, func(string, []byte) {}), "subscribe timed out")
+	assert.ErrorContains(t, c.Unsubscribe("b/topic"), "unsubscribe timed out")
+	c.onConnect(fake)
+	assert.Equal(t, []string{"a/topic", "b/topic", "a/topic"}, fake.subscriptions())
+}
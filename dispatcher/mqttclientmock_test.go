@@ -1,6 +1,9 @@
 package dispatcher
 
-import "sync"
+import (
+	"go-mqtt-dispatcher/config"
+	"sync"
+)
 
 type MockMqttClient struct {
 	// mu guards all maps below; Publish/Subscribe are invoked from background
@@ -8,8 +11,14 @@ type MockMqttClient struct {
 	mu                sync.Mutex
 	PublishedMessages map[string][]byte
 	PublishCount      map[string]int
-	Subscriptions     map[string]func([]byte)
-	Log               func(s string)
+	PublishOptions    map[string]PublishOptions
+	Subscriptions     map[string]func(string, []byte)
+	SubscriptionOpts  map[string]SubscribeOptions
+	Disconnected      bool
+	// SubscribeErr is returned by the next Subscribe, which keeps the
+	// subscription like the real clients do.
+	SubscribeErr error
+	Log          func(s string)
 }
 
 func NewMockMqttClient(logger ...func(s string)) *MockMqttClient {
@@ -22,38 +31,73 @@ func NewMockMqttClient(logger ...func(s string)) *MockMqttClient {
 	return &MockMqttClient{
 		PublishedMessages: make(map[string][]byte),
 		PublishCount:      make(map[string]int),
-		Subscriptions:     make(map[string]func([]byte)),
+		PublishOptions:    make(map[string]PublishOptions),
+		Subscriptions:     make(map[string]func(string, []byte)),
+		SubscriptionOpts:  make(map[string]SubscribeOptions),
 		Log:               logger[0],
 	}
 }
 
-func (m *MockMqttClient) Publish(topic string, payload []byte) error {
+func (m *MockMqttClient) Publish(topic string, payload []byte, opts PublishOptions) error {
 	m.Log("Publishing to '" + topic + "': '" + string(payload) + "'")
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.PublishedMessages[topic] = payload
+	m.PublishOptions[topic] = opts
 	m.PublishCount[topic]++
 	return nil
 }
 
-func (m *MockMqttClient) Subscribe(topic string, callback func([]byte)) error {
+func (m *MockMqttClient) Subscribe(topic string, opts SubscribeOptions, callback func(string, []byte)) error {
 	m.Log("Subscribing to '" + topic + "'")
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.Subscriptions[topic] = callback
+	m.SubscriptionOpts[topic] = opts
+	err := m.SubscribeErr
+	m.SubscribeErr = nil
+	return err
+}
+
+func (m *MockMqttClient) Unsubscribe(topics ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, topic := range topics {
+		m.Log("Unsubscribing from '" + topic + "'")
+		delete(m.Subscriptions, topic)
+	}
 	return nil
 }
 
-// Helper method for testing
+func (m *MockMqttClient) IsConnected() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.Disconnected
+}
+
+// SetConnected simulates a lost or restored broker connection.
+func (m *MockMqttClient) SetConnected(connected bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Disconnected = !connected
+}
+
+// SimulateMessage delivers payload to every subscription matching topic, like
+// a broker does for wildcard subscriptions.
 func (m *MockMqttClient) SimulateMessage(topic string, payload []byte) {
 	m.Log("Simulating message for '" + topic + "'")
-	// Resolve the callback under the lock, but invoke it outside to avoid
-	// deadlocking with Publish (which the callback calls).
+	// Resolve the callbacks under the lock, but invoke them outside to avoid
+	// deadlocking with Publish (which the callbacks call).
 	m.mu.Lock()
-	callback, ok := m.Subscriptions[topic]
+	var callbacks []func(string, []byte)
+	for filter, callback := range m.Subscriptions {
+		if _, ok := config.MatchTopic(filter, topic); ok {
+			callbacks = append(callbacks, callback)
+		}
+	}
 	m.mu.Unlock()
-	if ok {
-		callback(payload)
+	for _, callback := range callbacks {
+		callback(topic, payload)
 	}
 }
 
@@ -1,7 +1,16 @@
 package dispatcher
 
+import "go-mqtt-dispatcher/config"
+
 type publishMessage struct {
-	Text  string `json:"text"`
-	Icon  string `json:"icon,omitempty"`
-	Color string `json:"color,omitempty"`
+	// Text is a string or, with output-fragments, a []config.TextFragment.
+	Text  interface{} `json:"text"`
+	Icon  string      `json:"icon,omitempty"`
+	Color string      `json:"color,omitempty"`
+
+	// Awtrix custom app options, their keys follow text, icon and color.
+	*config.AwtrixDefinition
+
+	// history is published as bar or line by an output-as-history-chart.
+	history []float64
 }
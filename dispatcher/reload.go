@@ -0,0 +1,131 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"go-mqtt-dispatcher/config"
+	"strings"
+	"sync"
+)
+
+// runningEntry is an entry started by Run or Reload together with the cancel
+// func of its triggers and the goroutines they run in.
+type runningEntry struct {
+	entry   config.Entry
+	cancel  context.CancelFunc
+	workers *sync.WaitGroup
+}
+
+// entryWorkersKey is the context key of the workers of an entry.
+type entryWorkersKey struct{}
+
+// addWorker registers a goroutine started with ctx at the dispatcher and, if
+// ctx belongs to an entry, at the entry, so Reload can wait for a stopped entry
+// before clearing its state. The returned func must be called when it returns.
+func (d *Dispatcher) addWorker(ctx context.Context) func() {
+	d.wg.Add(1)
+	workers, _ := ctx.Value(entryWorkersKey{}).(*sync.WaitGroup)
+	if workers != nil {
+		workers.Add(1)
+	}
+	return func() {
+		if workers != nil {
+			workers.Done()
+		}
+		d.wg.Done()
+	}
+}
+
+type keyedEntry struct {
+	key   string
+	entry config.Entry
+}
+
+// entryKeys keys every entry by its fingerprint, so an unchanged entry keeps its
+// key across reloads. Identical entries are told apart by their occurrence.
+func entryKeys(entries []config.Entry) []keyedEntry {
+	seen := make(map[string]int)
+	keyed := make([]keyedEntry, 0, len(entries))
+	for _, e := range entries {
+		fp := e.Fingerprint()
+		key := fmt.Sprintf("%s#%d", fp, seen[fp])
+		seen[fp]++
+		keyed = append(keyed, keyedEntry{key: key, entry: e})
+	}
+	return keyed
+}
+
+// Reload replaces the running entries with entries. Unchanged entries keep
+// running untouched, removed and changed entries are stopped (their
+// subscriptions, pollers and state are dropped) and added and changed entries
+// are started. Reload must be called after Run.
+func (d *Dispatcher) Reload(entries *[]config.Entry) {
+	keyed := entryKeys(*entries)
+	wanted := make(map[string]bool, len(keyed))
+	for _, k := range keyed {
+		wanted[k.key] = true
+	}
+
+	d.mu.Lock()
+	ctx := d.runCtx
+	var stopped []*runningEntry
+	for key, r := range d.running {
+		if !wanted[key] {
+			stopped = append(stopped, r)
+			delete(d.running, key)
+		}
+	}
+	var started []keyedEntry
+	for _, k := range keyed {
+		if _, ok := d.running[k.key]; !ok {
+			started = append(started, k)
+		}
+	}
+	d.entries = entries
+	d.mu.Unlock()
+
+	if ctx == nil {
+		d.log.Warn("Reload ignored, dispatcher is not running")
+		return
+	}
+
+	for _, r := range stopped {
+		d.entryLog(r.entry).Info("Stopping entry")
+		r.cancel()
+		// Pollers and handlers may still be writing the state of the entry
+		r.workers.Wait()
+		d.clearEntryState(r.entry)
+		d.metrics.deleteEntry(r.entry.Name)
+	}
+	for _, k := range started {
+		d.entryLog(k.entry).Info("Reload starting entry")
+		d.startEntry(ctx, k.key, k.entry)
+	}
+	d.log.Info("Reload done", "stopped", len(stopped), "started", len(started), "unchanged", len(keyed)-len(started))
+}
+
+// clearEntryState drops the accumulation, fallback, history and status state of a stopped entry,
+// so a changed entry starts fresh.
+func (d *Dispatcher) clearEntryState(entry config.Entry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// The topics matched by wildcards are only known from the state
+	for id := range d.state[entry.Name] {
+		delete(d.expired, fallbackKey(entry.Name, id))
+	}
+	for _, id := range entry.SourceIDs() {
+		delete(d.expired, fallbackKey(entry.Name, id))
+	}
+	delete(d.state, entry.Name)
+	delete(d.updated, entry.Name)
+	delete(d.status, entry.Name)
+	for _, pub := range entry.TopicsToPublish {
+		delete(d.fallbacks, fallbackKey(entry.Name, pub.Topic))
+	}
+	// The history of a publish topic with placeholders is kept per expanded topic
+	for key := range d.history {
+		if strings.HasPrefix(key, fallbackKey(entry.Name, "")) {
+			delete(d.history, key)
+		}
+	}
+}
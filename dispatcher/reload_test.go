@@ -0,0 +1,138 @@
+package dispatcher
+
+import (
+	"context"
+	"go-mqtt-dispatcher/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMqttEntry(name, subTopic, pubTopic string) config.Entry {
+	return config.Entry{
+		Name: name,
+		Source: config.EntrySource{
+			MqttSource: &config.MqttSource{
+				TopicsToSubscribe: []config.MqttTopicDefinition{{Topic: subTopic}},
+			},
+		},
+		TopicsToPublish: []config.MqttTopicDefinition{{Topic: pubTopic}},
+	}
+}
+
+func TestReload(t *testing.T) {
+	log := func(s string) { t.Log(s) }
+	mc := NewMockMqttClient(log)
+
+	kept := newMqttEntry("kept", "sub/kept", "pub/kept")
+	removed := newMqttEntry("removed", "sub/removed", "pub/removed")
+	changed := newMqttEntry("changed", "sub/changed", "pub/changed")
+
+	d, err := NewDispatcher(&[]config.Entry{kept, removed, changed}, mc, newTestLogger(t))
+	require.NoError(t, err)
+	d.Run(context.Background())
+	defer d.Stop()
+
+	assert.True(t, mc.IsSubscribed("sub/kept"))
+	assert.True(t, mc.IsSubscribed("sub/removed"))
+	assert.True(t, mc.IsSubscribed("sub/changed"))
+
+	changedNew := newMqttEntry("changed", "sub/changed-new", "pub/changed")
+	added := newMqttEntry("added", "sub/added", "pub/added")
+	d.Reload(&[]config.Entry{kept, changedNew, added})
+	// Removals happen in the per-entry goroutines once their context is cancelled.
+	assert.Eventually(t, func() bool {
+		return !mc.IsSubscribed("sub/removed") && !mc.IsSubscribed("sub/changed")
+	}, time.Second, time.Millisecond)
+
+	assert.True(t, mc.IsSubscribed("sub/kept"))
+	assert.True(t, mc.IsSubscribed("sub/changed-new"))
+	assert.True(t, mc.IsSubscribed("sub/added"))
+
+	mc.SimulateMessage("sub/kept", []byte(`1`))
+	mc.SimulateMessage("sub/changed-new", []byte(`2`))
+	mc.SimulateMessage("sub/added", []byte(`3`))
+	assert.Equal(t, `{"text":"1"}`, lastMessage(mc, "pub/kept"))
+	assert.Equal(t, `{"text":"2"}`, lastMessage(mc, "pub/changed"))
+	assert.Equal(t, `{"text":"3"}`, lastMessage(mc, "pub/added"))
+}
+
+func TestReloadKeepsSharedSubscription(t *testing.T) {
+	log := func(s string) { t.Log(s) }
+	mc := NewMockMqttClient(log)
+
+	a := newMqttEntry("a", "sub/shared", "pub/a")
+	b := newMqttEntry("b", "sub/shared", "pub/b")
+
+	d, err := NewDispatcher(&[]config.Entry{a, b}, mc, newTestLogger(t))
+	require.NoError(t, err)
+	d.Run(context.Background())
+	defer d.Stop()
+
+	// Both entries receive the payload of the shared source topic.
+	mc.SimulateMessage("sub/shared", []byte(`5`))
+	assert.Equal(t, `{"text":"5"}`, lastMessage(mc, "pub/a"))
+	assert.Equal(t, `{"text":"5"}`, lastMessage(mc, "pub/b"))
+
+	// Removing one entry keeps the broker subscription for the other.
+	d.Reload(&[]config.Entry{b})
+	assert.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.routes[routeKey{topic: "sub/shared"}]) == 1
+	}, time.Second, time.Millisecond)
+	assert.True(t, mc.IsSubscribed("sub/shared"))
+
+	mc.SimulateMessage("sub/shared", []byte(`6`))
+	assert.Equal(t, `{"text":"5"}`, lastMessage(mc, "pub/a"))
+	assert.Equal(t, `{"text":"6"}`, lastMessage(mc, "pub/b"))
+}
+
+// blockingMqttClient holds publishes to topic until release is closed.
+type blockingMqttClient struct {
+	*MockMqttClient
+	topic   string
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingMqttClient) Publish(topic string, payload []byte, opts PublishOptions) error {
+	if topic == c.topic {
+		close(c.entered)
+		<-c.release
+	}
+	return c.MockMqttClient.Publish(topic, payload, opts)
+}
+
+func TestReloadWaitsForRunningHandlers(t *testing.T) {
+	mc := &blockingMqttClient{MockMqttClient: NewMockMqttClient(), topic: "pub/removed", entered: make(chan struct{}), release: make(chan struct{})}
+	removed := newMqttEntry("removed", "sub/removed", "pub/removed")
+
+	d, err := NewDispatcher(&[]config.Entry{removed}, mc, newTestLogger(t))
+	require.NoError(t, err)
+	d.Run(context.Background())
+	defer d.Stop()
+
+	go mc.SimulateMessage("sub/removed", []byte(`1`))
+	<-mc.entered
+
+	reloaded := make(chan struct{})
+	go func() {
+		d.Reload(&[]config.Entry{})
+		close(reloaded)
+	}()
+	select {
+	case <-reloaded:
+		t.Fatal("Reload returned while a handler of the removed entry was running")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The handler finishes first, its status is cleared afterwards.
+	close(mc.release)
+	<-reloaded
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	assert.Nil(t, d.status["removed"])
+}
@@ -0,0 +1,194 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateStore persists the source values and fallback tracks of the dispatcher
+// as json file, so accumulation and fallbacks survive a restart.
+type StateStore struct {
+	path   string
+	maxAge time.Duration
+}
+
+// NewStateStore returns a store for the file at path. Values older than maxAge
+// are discarded when the state is restored.
+func NewStateStore(path string, maxAge time.Duration) *StateStore {
+	return &StateStore{path: path, maxAge: maxAge}
+}
+
+// Option configures a Dispatcher in NewDispatcher.
+type Option func(*Dispatcher)
+
+// WithStateStore restores the state from store in NewDispatcher and saves it
+// every interval while running and on Stop.
+func WithStateStore(store *StateStore, interval time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.store = store
+		d.storeInterval = interval
+	}
+}
+
+// stateFile is the json layout of the state file.
+type stateFile struct {
+	Saved     time.Time                          `json:"saved"`
+	Sources   map[string]map[string]sourceValue  `json:"sources"`   // entry name -> source id
+	Fallbacks map[string]map[string]fallbackJSON `json:"fallbacks"` // entry name -> publish topic
+}
+
+type sourceValue struct {
+	Value float64   `json:"value"`
+	At    time.Time `json:"at"`
+}
+
+type fallbackJSON struct {
+	LastActivity time.Time `json:"last-activity"`
+	LastValue    string    `json:"last-value,omitempty"`
+	HasValue     bool      `json:"has-value,omitempty"`
+	LastChange   time.Time `json:"last-change"`
+	Fired        bool      `json:"fired,omitempty"`
+}
+
+// snapshot copies the state of the dispatcher. Callers hold d.mu.
+func (d *Dispatcher) snapshot() stateFile {
+	s := stateFile{
+		Saved:     now(),
+		Sources:   make(map[string]map[string]sourceValue),
+		Fallbacks: make(map[string]map[string]fallbackJSON),
+	}
+	for name, sources := range d.state {
+		s.Sources[name] = make(map[string]sourceValue, len(sources))
+		for id, v := range sources {
+			s.Sources[name][id] = sourceValue{Value: v, At: d.updated[name][id]}
+		}
+	}
+	for _, e := range *d.entries {
+		for _, pub := range e.TopicsToPublish {
+			t := d.fallbacks[fallbackKey(e.Name, pub.Topic)]
+			if t == nil {
+				continue
+			}
+			if s.Fallbacks[e.Name] == nil {
+				s.Fallbacks[e.Name] = make(map[string]fallbackJSON)
+			}
+			s.Fallbacks[e.Name][pub.Topic] = fallbackJSON{
+				LastActivity: t.lastActivity,
+				LastValue:    t.lastValue,
+				HasValue:     t.hasValue,
+				LastChange:   t.lastChange,
+				Fired:        t.fired,
+			}
+		}
+	}
+	return s
+}
+
+// restore applies a saved state to the configured entries. Source values and
+// fallback tracks older than the store's max age are discarded.
+func (d *Dispatcher) restore(s stateFile) (sources, fallbacks int) {
+	oldest := now().Add(-d.store.maxAge)
+	for _, e := range *d.entries {
+		for id, v := range s.Sources[e.Name] {
+			if !e.MatchesSourceID(id) || v.At.Before(oldest) {
+				continue
+			}
+			if d.state[e.Name] == nil {
+				d.state[e.Name] = make(map[string]float64)
+				d.updated[e.Name] = make(map[string]time.Time)
+			}
+			d.state[e.Name][id] = v.Value
+			d.updated[e.Name][id] = v.At
+			sources++
+		}
+		if !e.HasFallback() {
+			continue
+		}
+		for _, pub := range e.TopicsToPublish {
+			f, ok := s.Fallbacks[e.Name][pub.Topic]
+			if !ok || f.LastActivity.Before(oldest) {
+				continue
+			}
+			d.fallbacks[fallbackKey(e.Name, pub.Topic)] = &fallbackTrack{
+				lastActivity: f.LastActivity,
+				lastValue:    f.LastValue,
+				hasValue:     f.HasValue,
+				lastChange:   f.LastChange,
+				fired:        f.Fired,
+			}
+			fallbacks++
+		}
+	}
+	return sources, fallbacks
+}
+
+// loadState restores the state file, a missing file is not an error.
+func (d *Dispatcher) loadState() error {
+	data, err := os.ReadFile(d.store.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var s stateFile
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid state file %s: %v", d.store.path, err)
+	}
+
+	d.mu.Lock()
+	sources, fallbacks := d.restore(s)
+	d.mu.Unlock()
+	d.log.Info("Restored state", "file", d.store.path, "sources", sources, "fallbacks", fallbacks)
+	return nil
+}
+
+// saveState writes the state file. It writes to a temporary file first, so a
+// crash never leaves a truncated state file.
+func (d *Dispatcher) saveState() error {
+	d.mu.Lock()
+	s := d.snapshot()
+	d.mu.Unlock()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(d.store.path), filepath.Base(d.store.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.store.path)
+}
+
+// runStateSaver saves the state every storeInterval until ctx is done.
+func (d *Dispatcher) runStateSaver(ctx context.Context) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := getTicker(d.storeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.saveState(); err != nil {
+					d.log.Error("Error saving state", "error", err)
+				}
+			}
+		}
+	}()
+}
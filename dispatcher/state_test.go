@@ -0,0 +1,89 @@
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"go-mqtt-dispatcher/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateStoreRestore(t *testing.T) {
+	log := func(s string) {
+		t.Log(s)
+	}
+
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	origNow := now
+	now = func() time.Time { return clock }
+	defer func() { now = origNow }()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	entries := []config.Entry{newAccumulateEntry("sum")}
+	entries[0].Fallback = &config.FallbackDefinition{Mode: "no-value-read", After: "1h", Value: "?", Color: "#888888"}
+	entries[0].FallbackAfter = time.Hour
+
+	// First run: grid and solar report, then the dispatcher is stopped.
+	mqttClient := NewMockMqttClient(log)
+	d, err := NewDispatcher(&entries, mqttClient, newTestLogger(t), WithStateStore(NewStateStore(path, time.Hour), time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create dispatcher: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.seedFallback(entries[0])
+	d.runMqtt(ctx, config.MqttEntryImpl{Entry: entries[0]})
+	mqttClient.SimulateMessage("grid", []byte(`100`))
+	clock = clock.Add(30 * time.Minute)
+	mqttClient.SimulateMessage("solar", []byte(`20`))
+	cancel()
+	d.Stop()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Expected state file: %v", err)
+	}
+
+	// Second run 45 minutes later: grid is older than max-age and discarded.
+	clock = clock.Add(45 * time.Minute)
+	mqttClient = NewMockMqttClient(log)
+	d, err = NewDispatcher(&entries, mqttClient, newTestLogger(t), WithStateStore(NewStateStore(path, time.Hour), time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create dispatcher: %v", err)
+	}
+	if _, ok := d.state["accEntry"]["grid"]; ok {
+		t.Errorf("Expected stale grid value to be discarded")
+	}
+	if v := d.state["accEntry"]["solar"]; v != 20 {
+		t.Errorf("Expected restored solar value 20, got %v", v)
+	}
+	track := d.fallbacks[fallbackKey("accEntry", "house")]
+	if track == nil || !track.lastActivity.Equal(clock.Add(-45*time.Minute)) {
+		t.Errorf("Expected restored fallback track, got %+v", track)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	d.runMqtt(ctx, config.MqttEntryImpl{Entry: entries[0]})
+	mqttClient.SimulateMessage("battery", []byte(`5`))
+	expected := `{"text":"25"}`
+	if msg := lastMessage(mqttClient, "house"); msg != expected {
+		t.Errorf("Expected message %s, but got %s", expected, msg)
+	}
+}
+
+func TestStateStoreInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	os.WriteFile(path, []byte("not json"), 0o600)
+
+	var logs bytes.Buffer
+	entries := []config.Entry{newAccumulateEntry("sum")}
+	_, err := NewDispatcher(&entries, NewMockMqttClient(nil), newBufferLogger(&logs), WithStateStore(NewStateStore(path, time.Hour), time.Minute))
+	if err != nil {
+		t.Fatalf("Expected invalid state file to be ignored, got %v", err)
+	}
+	if !strings.Contains(logs.String(), "Error restoring state") {
+		t.Errorf("Expected the invalid state file to be logged")
+	}
+}
@@ -0,0 +1,197 @@
+package dispatcher
+
+import "time"
+
+// maxStatusPayload caps the raw payloads kept for the status api.
+const maxStatusPayload = 4096
+
+// TimedValue is a value with the time it was received, published or resolved.
+type TimedValue struct {
+	Value string    `json:"value"`
+	At    time.Time `json:"at"`
+}
+
+// SourceStatus is the accumulation state of one source.
+type SourceStatus struct {
+	Value   float64   `json:"value"`
+	At      time.Time `json:"at"`
+	Expired bool      `json:"expired,omitempty"`
+}
+
+// FallbackStatus is the fallback state of one publish topic.
+type FallbackStatus struct {
+	Mode         string    `json:"mode"`
+	After        string    `json:"after"`
+	LastActivity time.Time `json:"last-activity"`
+	LastChange   time.Time `json:"last-change"`
+	LastValue    string    `json:"last-value,omitempty"`
+	Fired        bool      `json:"fired"`
+}
+
+// EntryStatus is the live state of a configured entry.
+type EntryStatus struct {
+	Name     string `json:"name"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Disabled bool   `json:"disabled"`
+
+	LastPayloads  map[string]TimedValue     `json:"last-payloads"`  // source id
+	LastValues    map[string]TimedValue     `json:"last-values"`    // publish topic
+	LastPublished map[string]TimedValue     `json:"last-published"` // publish topic
+	Accumulation  map[string]SourceStatus   `json:"accumulation,omitempty"`
+	Fallbacks     map[string]FallbackStatus `json:"fallbacks,omitempty"`
+}
+
+// entryStatus records what an entry received and published, keyed like EntryStatus.
+type entryStatus struct {
+	payloads  map[string]TimedValue
+	values    map[string]TimedValue
+	published map[string]TimedValue
+}
+
+// statusOf returns the status of the entry, created on first use. Callers hold d.mu.
+func (d *Dispatcher) statusOf(entryName string) *entryStatus {
+	s := d.status[entryName]
+	if s == nil {
+		s = &entryStatus{
+			payloads:  make(map[string]TimedValue),
+			values:    make(map[string]TimedValue),
+			published: make(map[string]TimedValue),
+		}
+		d.status[entryName] = s
+	}
+	return s
+}
+
+func (d *Dispatcher) recordPayload(entryName, sourceID string, payload []byte) {
+	if len(payload) > maxStatusPayload {
+		payload = payload[:maxStatusPayload]
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statusOf(entryName).payloads[sourceID] = TimedValue{Value: string(payload), At: now()}
+}
+
+func (d *Dispatcher) recordValue(entryName, pubTopic, value string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statusOf(entryName).values[pubTopic] = TimedValue{Value: value, At: now()}
+}
+
+func (d *Dispatcher) recordPublished(entryName, pubTopic string, payload []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statusOf(entryName).published[pubTopic] = TimedValue{Value: string(payload), At: now()}
+}
+
+// IsConnected reports whether the connections to all brokers are up.
+func (d *Dispatcher) IsConnected() bool {
+	if !d.mqttClient.IsConnected() {
+		return false
+	}
+	for _, c := range d.brokers {
+		if !c.IsConnected() {
+			return false
+		}
+	}
+	return true
+}
+
+// Status returns the live state of all configured entries in configured order.
+func (d *Dispatcher) Status() []EntryStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries := *d.entries
+	result := make([]EntryStatus, 0, len(entries))
+	for _, e := range entries {
+		s := EntryStatus{
+			Name:          e.Name,
+			Disabled:      e.Disabled,
+			LastPayloads:  make(map[string]TimedValue),
+			LastValues:    make(map[string]TimedValue),
+			LastPublished: make(map[string]TimedValue),
+		}
+		if id, ok := e.Identity(); ok {
+			s.ID = id.GetID()
+			s.Type = id.GetTypeName()
+		}
+		if st := d.status[e.Name]; st != nil {
+			copyTimed(s.LastPayloads, st.payloads)
+			copyTimed(s.LastValues, st.values)
+			copyTimed(s.LastPublished, st.published)
+		}
+
+		if len(d.state[e.Name]) > 0 {
+			s.Accumulation = make(map[string]SourceStatus)
+			for id, v := range d.state[e.Name] {
+				s.Accumulation[id] = SourceStatus{Value: v, At: d.updated[e.Name][id], Expired: d.expired[fallbackKey(e.Name, id)]}
+			}
+		}
+
+		if e.HasFallback() {
+			s.Fallbacks = make(map[string]FallbackStatus)
+			for _, pub := range e.TopicsToPublish {
+				t := d.fallbacks[fallbackKey(e.Name, pub.Topic)]
+				if t == nil {
+					continue
+				}
+				s.Fallbacks[pub.Topic] = FallbackStatus{
+					Mode:         string(e.FallbackMode()),
+					After:        e.FallbackAfter.String(),
+					LastActivity: t.lastActivity,
+					LastChange:   t.lastChange,
+					LastValue:    t.lastValue,
+					Fired:        t.fired,
+				}
+			}
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+func copyTimed(dst, src map[string]TimedValue) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// PublishEvent is a message published by an entry.
+type PublishEvent struct {
+	Entry   string    `json:"entry"`
+	Topic   string    `json:"topic"`
+	Payload string    `json:"payload"`
+	At      time.Time `json:"at"`
+}
+
+// AddPublishListener calls listener for every published message until the
+// returned func is called. listener is called on the publishing goroutine and
+// must not block.
+func (d *Dispatcher) AddPublishListener(listener func(PublishEvent)) (remove func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextListenerID++
+	id := d.nextListenerID
+	d.listeners[id] = listener
+	return func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		delete(d.listeners, id)
+	}
+}
+
+// notifyPublished hands a published message to all listeners outside the lock.
+func (d *Dispatcher) notifyPublished(entryName, topic string, payload []byte) {
+	d.mu.Lock()
+	listeners := make([]func(PublishEvent), 0, len(d.listeners))
+	for _, l := range d.listeners {
+		listeners = append(listeners, l)
+	}
+	d.mu.Unlock()
+
+	e := PublishEvent{Entry: entryName, Topic: topic, Payload: string(payload), At: now()}
+	for _, l := range listeners {
+		l(e)
+	}
+}
@@ -0,0 +1,66 @@
+package dispatcher
+
+import (
+	"context"
+	"go-mqtt-dispatcher/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatus(t *testing.T) {
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	origNow := now
+	now = func() time.Time { return clock }
+	defer func() { now = origNow }()
+
+	mqttClient := NewMockMqttClient()
+	entry := newAccumulateEntry("sum")
+	entries := []config.Entry{entry, {Name: "off", Disabled: true}}
+	d, err := NewDispatcher(&entries, mqttClient, nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.runMqtt(ctx, config.MqttEntryImpl{Entry: entry})
+
+	mqttClient.SimulateMessage("grid", []byte(`800`))
+	mqttClient.SimulateMessage("solar", []byte(`300`))
+
+	status := d.Status()
+	require.Len(t, status, 2)
+	s := status[0]
+	assert.Equal(t, "accEntry", s.Name)
+	assert.Equal(t, config.MqttEntryImpl{Entry: entry}.GetID(), s.ID)
+	assert.Equal(t, "Mqtt", s.Type)
+	assert.Equal(t, TimedValue{Value: "300", At: clock}, s.LastPayloads["solar"])
+	assert.Equal(t, TimedValue{Value: "1100", At: clock}, s.LastValues["house"])
+	assert.Equal(t, TimedValue{Value: `{"text":"1100"}`, At: clock}, s.LastPublished["house"])
+	assert.Equal(t, SourceStatus{Value: 800, At: clock}, s.Accumulation["grid"])
+
+	assert.Equal(t, EntryStatus{
+		Name:          "off",
+		Disabled:      true,
+		LastPayloads:  map[string]TimedValue{},
+		LastValues:    map[string]TimedValue{},
+		LastPublished: map[string]TimedValue{},
+	}, status[1])
+	assert.True(t, d.IsConnected())
+}
+
+func TestPublishListener(t *testing.T) {
+	d, err := NewDispatcher(&[]config.Entry{}, NewMockMqttClient(), nil)
+	require.NoError(t, err)
+
+	var events []PublishEvent
+	remove := d.AddPublishListener(func(e PublishEvent) { events = append(events, e) })
+	d.publish(config.Entry{Name: "power"}, config.MqttTopicDefinition{Topic: "awtrix/power"}, []byte(`{"text":"1"}`))
+	remove()
+	d.publish(config.Entry{Name: "power"}, config.MqttTopicDefinition{Topic: "awtrix/power"}, []byte(`{"text":"2"}`))
+
+	require.Len(t, events, 1)
+	assert.Equal(t, "power", events[0].Entry)
+	assert.Equal(t, "awtrix/power", events[0].Topic)
+	assert.Equal(t, `{"text":"1"}`, events[0].Payload)
+}
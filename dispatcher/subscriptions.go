@@ -0,0 +1,128 @@
+package dispatcher
+
+import "fmt"
+
+// routeKey is a topic subscribed at a broker, "" is the default broker.
+type routeKey struct {
+	broker string
+	topic  string
+}
+
+// route is one entry's handler for a subscribed broker topic.
+type route struct {
+	id      uint64
+	opts    SubscribeOptions
+	handler func(topic string, payload []byte)
+}
+
+// combinedOptions returns the options of a broker subscription for routes, the
+// highest qos and no-local only if all routes use it.
+func combinedOptions(routes []route) SubscribeOptions {
+	var opts SubscribeOptions
+	for i, r := range routes {
+		opts.Qos = max(opts.Qos, r.opts.Qos)
+		opts.NoLocal = r.opts.NoLocal && (i == 0 || opts.NoLocal)
+	}
+	return opts
+}
+
+// subscribe registers handler for topic and subscribes at the broker when it is
+// the first handler for the topic. Several entries may use the same source topic,
+// each of them gets every payload. The broker subscription uses the combined
+// options of the handlers, it is subscribed again if a handler changes them.
+// topic may contain wildcards, the handlers get the matched topic. The returned
+// func removes the handler again and unsubscribes at the broker once no handler
+// is left. A subscription failing while the broker is disconnected is kept, the
+// client subscribes again after the reconnect.
+func (d *Dispatcher) subscribe(broker, topic string, opts SubscribeOptions, handler func(topic string, payload []byte)) (func(), error) {
+	client := d.client(broker)
+	if client == nil {
+		return nil, fmt.Errorf("unknown broker '%s'", broker)
+	}
+	key := routeKey{broker: broker, topic: topic}
+
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+
+	d.mu.Lock()
+	d.nextRouteID++
+	id := d.nextRouteID
+	first := len(d.routes[key]) == 0
+	before := combinedOptions(d.routes[key])
+	d.routes[key] = append(d.routes[key], route{id: id, opts: opts, handler: handler})
+	combined := combinedOptions(d.routes[key])
+	d.mu.Unlock()
+
+	if first || combined != before {
+		err := client.Subscribe(topic, combined, func(matched string, payload []byte) {
+			d.dispatchRoute(key, matched, payload)
+		})
+		if err != nil && !client.IsConnected() {
+			d.log.Warn("Subscribing failed while disconnected, retrying after reconnect", "topic", topic, "broker", broker, "error", err)
+		} else if err != nil {
+			d.removeRoute(key, id)
+			if first {
+				// the client keeps failed subscriptions for its resubscribe
+				client.Unsubscribe(topic)
+			}
+			return nil, err
+		}
+	}
+
+	return func() { d.unsubscribe(client, key, id) }, nil
+}
+
+// unsubscribe removes the handler with id from key and unsubscribes at the
+// broker if it was the last one.
+func (d *Dispatcher) unsubscribe(client MqttClient, key routeKey, id uint64) {
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+
+	last := d.removeRoute(key, id)
+	// Handlers that got the route before its removal may still be running
+	d.dispatching.Lock()
+	d.dispatching.Unlock()
+	if !last {
+		return
+	}
+	d.log.Info("Unsubscribing", "topic", key.topic, "broker", key.broker)
+	if err := client.Unsubscribe(key.topic); err != nil {
+		d.log.Error("Error unsubscribing from topic", "topic", key.topic, "broker", key.broker, "error", err)
+	}
+}
+
+// removeRoute deletes the handler with id and reports whether key has no handlers left.
+func (d *Dispatcher) removeRoute(key routeKey, id uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	routes := d.routes[key]
+	for i, r := range routes {
+		if r.id == id {
+			routes = append(routes[:i:i], routes[i+1:]...)
+			break
+		}
+	}
+	if len(routes) == 0 {
+		delete(d.routes, key)
+		return true
+	}
+	d.routes[key] = routes
+	return false
+}
+
+// dispatchRoute hands a payload received on the matched topic to every handler
+// of key. The handlers are invoked outside the lock because they take d.mu
+// themselves.
+func (d *Dispatcher) dispatchRoute(key routeKey, matched string, payload []byte) {
+	d.dispatching.RLock()
+	defer d.dispatching.RUnlock()
+
+	d.mu.Lock()
+	routes := append([]route(nil), d.routes[key]...)
+	d.mu.Unlock()
+
+	for _, r := range routes {
+		r.handler(matched, payload)
+	}
+}
@@ -0,0 +1,126 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"go-mqtt-dispatcher/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQosAndRetain(t *testing.T) {
+	log := func(s string) { t.Log(s) }
+	mc := NewMockMqttClient(log)
+
+	a := newMqttEntry("a", "sub/shared", "pub/a")
+	a.TopicsToPublish[0].ResolvedQos = 1
+	a.TopicsToPublish[0].ResolvedRetain = true
+	b := newMqttEntry("b", "sub/shared", "pub/b")
+	b.Source.MqttSource.TopicsToSubscribe[0].ResolvedQos = 2
+
+	d, err := NewDispatcher(&[]config.Entry{a, b}, mc, newTestLogger(t))
+	require.NoError(t, err)
+	d.Run(context.Background())
+	defer d.Stop()
+
+	// The shared subscription uses the highest qos of its routes.
+	mc.mu.Lock()
+	assert.Equal(t, byte(2), mc.SubscriptionOpts["sub/shared"].Qos)
+	mc.mu.Unlock()
+
+	mc.SimulateMessage("sub/shared", []byte(`5`))
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	assert.Equal(t, PublishOptions{Qos: 1, Retain: true, UserProperties: map[string]string{"entry": config.MqttEntryImpl{Entry: a}.GetID()}}, mc.PublishOptions["pub/a"])
+	assert.Equal(t, PublishOptions{UserProperties: map[string]string{"entry": config.MqttEntryImpl{Entry: b}.GetID()}}, mc.PublishOptions["pub/b"])
+}
+
+func TestMqtt5Options(t *testing.T) {
+	log := func(s string) { t.Log(s) }
+	mc := NewMockMqttClient(log)
+
+	a := newMqttEntry("a", "sub/shared", "pub/a")
+	a.Source.MqttSource.TopicsToSubscribe[0].ShareGroup = "dispatchers"
+	a.TopicsToPublish[0].ResolvedMessageExpiry = time.Minute
+	a.TopicsToPublish[0].ContentType = "application/json"
+	a.TopicsToPublish[0].UserProperties = map[string]string{"unit": "W"}
+	a.TopicsToPublish[0].ResponseTopic = "pub/a/reply"
+	a.TopicsToPublish[0].CorrelationData = "a"
+	b := newMqttEntry("b", "sub/local", "pub/b")
+	b.Source.MqttSource.TopicsToSubscribe[0].NoLocal = true
+	c := newMqttEntry("c", "sub/local", "pub/c")
+
+	d, err := NewDispatcher(&[]config.Entry{a, b, c}, mc, newTestLogger(t))
+	require.NoError(t, err)
+	d.Run(context.Background())
+	defer d.Stop()
+
+	// Shared subscriptions use the $share filter, no-local only applies if all
+	// routes of the topic want it.
+	mc.mu.Lock()
+	assert.Contains(t, mc.Subscriptions, "$share/dispatchers/sub/shared")
+	assert.Equal(t, SubscribeOptions{}, mc.SubscriptionOpts["sub/local"])
+	mc.mu.Unlock()
+
+	mc.SimulateMessage("sub/shared", []byte(`5`))
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	assert.Equal(t, PublishOptions{
+		MessageExpiry:   time.Minute,
+		ContentType:     "application/json",
+		UserProperties:  map[string]string{"unit": "W", "entry": config.MqttEntryImpl{Entry: a}.GetID()},
+		ResponseTopic:   "pub/a/reply",
+		CorrelationData: []byte("a"),
+	}, mc.PublishOptions["pub/a"])
+}
+
+func TestSubscribeWhileDisconnected(t *testing.T) {
+	log := func(s string) { t.Log(s) }
+	mc := NewMockMqttClient(log)
+	mc.SetConnected(false)
+	mc.SubscribeErr = errors.New("not connected")
+
+	d, err := NewDispatcher(&[]config.Entry{newMqttEntry("a", "sub/a", "pub/a")}, mc, newTestLogger(t))
+	require.NoError(t, err)
+	d.Run(context.Background())
+	defer d.Stop()
+
+	// The client resubscribes after the reconnect, the route has to be there.
+	mc.SetConnected(true)
+	mc.SimulateMessage("sub/a", []byte(`5`))
+	payload, ok := mc.GetPublishedMessage("pub/a")
+	require.True(t, ok)
+	assert.Equal(t, `{"text":"5"}`, string(payload))
+}
+
+func TestSubscribeRejected(t *testing.T) {
+	log := func(s string) { t.Log(s) }
+	mc := NewMockMqttClient(log)
+	mc.SubscribeErr = errors.New("not authorized")
+
+	d, err := NewDispatcher(&[]config.Entry{newMqttEntry("a", "sub/a", "pub/a")}, mc, newTestLogger(t))
+	require.NoError(t, err)
+	d.Run(context.Background())
+	defer d.Stop()
+
+	// A failure while connected drops the subscription in both layers.
+	assert.False(t, mc.IsSubscribed("sub/a"))
+	d.mu.Lock()
+	assert.Empty(t, d.routes)
+	d.mu.Unlock()
+}
+
+func TestCombinedOptions(t *testing.T) {
+	assert.Equal(t, SubscribeOptions{}, combinedOptions(nil))
+	assert.Equal(t, SubscribeOptions{Qos: 1, NoLocal: true}, combinedOptions([]route{
+		{opts: SubscribeOptions{NoLocal: true}},
+		{opts: SubscribeOptions{Qos: 1, NoLocal: true}},
+	}))
+	assert.Equal(t, SubscribeOptions{Qos: 2}, combinedOptions([]route{
+		{opts: SubscribeOptions{Qos: 2}},
+		{opts: SubscribeOptions{NoLocal: true}},
+	}))
+}
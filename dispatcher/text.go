@@ -0,0 +1,82 @@
+package dispatcher
+
+import (
+	"errors"
+	"fmt"
+	"go-mqtt-dispatcher/config"
+	"log/slog"
+	"strconv"
+)
+
+// callbackText handles sources with value-type string or bool. Text values are
+// never accumulated or filtered; mapping, outputFormat and the color-script
+// still apply.
+func (d *Dispatcher) callbackText(log *slog.Logger, payload []byte, c callbackConfig, publish func([]byte)) {
+	text, err := d.transformPayloadText(payload, c.TransSource)
+	if err != nil {
+		log.Warn("Transform error", "error", err, "payload", shortenPayload(payload))
+		d.metrics.transformFailed(c.Entry.Name, errors.Is(err, errJsonPath))
+		d.errors.transformFailed(errors.Is(err, errJsonPath))
+		return
+	}
+
+	if c.Entry.HasFallback() {
+		d.markValue(c.Entry.Name, c.PubTopic, text)
+	}
+	d.metrics.observeValue(c.Entry.Name, c.PubTopic, nil, now())
+	d.recordValue(c.Entry.Name, c.PubTopic, text)
+
+	pubMsg := publishMessage{}
+	pubMsg.Text = outputFormatText(text, c.TransTarget)
+
+	if c.Entry.TextColorCallback != nil {
+		if color, err := c.Entry.TextColorCallback(text); err == nil {
+			pubMsg.Color = color
+		}
+	}
+
+	d.sendMessage(pubMsg, c, text, publish)
+}
+
+// textSource returns the source as ValueTypeSource if it delivers text values.
+func textSource(t config.TransformSource) (config.ValueTypeSource, bool) {
+	vt, ok := t.(config.ValueTypeSource)
+	if !ok || !vt.GetValueType().IsText() {
+		return nil, false
+	}
+	return vt, true
+}
+
+// transformPayloadText extracts the value as text. Bool values are normalized
+// to "true" or "false", then the mapping of the transform is applied.
+func (d *Dispatcher) transformPayloadText(payload []byte, t config.TransformSource) (string, error) {
+	text, err := d.extractValue(payload, t.GetJsonPath())
+	if err != nil {
+		return "", err
+	}
+
+	vt, ok := textSource(t)
+	if !ok {
+		return text, nil
+	}
+
+	if vt.GetValueType() == config.ValueTypeBool {
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return "", err
+		}
+		text = strconv.FormatBool(b)
+	}
+
+	if mapped, ok := vt.GetMapping()[text]; ok {
+		text = mapped
+	}
+	return text, nil
+}
+
+func outputFormatText(text string, o config.TransformTarget) string {
+	if o.GetOutputFormat() != "" {
+		return fmt.Sprintf(o.GetOutputFormat(), text)
+	}
+	return text
+}
@@ -0,0 +1,83 @@
+package dispatcher
+
+import (
+	"context"
+	"go-mqtt-dispatcher/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTextEntry(transform config.TransformDefinition, outputFormat string) config.Entry {
+	return config.Entry{
+		Name: "textEntry",
+		Icon: "door",
+		Source: config.EntrySource{
+			MqttSource: &config.MqttSource{
+				TopicsToSubscribe: []config.MqttTopicDefinition{{Topic: "sensor/door", Transform: transform}},
+			},
+		},
+		TopicsToPublish: []config.MqttTopicDefinition{
+			{Topic: "display/door", Transform: config.TransformDefinition{OutputFormat: outputFormat}},
+		},
+	}
+}
+
+func runTextEntry(t *testing.T, entry config.Entry, payloads ...string) *MockMqttClient {
+	t.Helper()
+	log := func(s string) { t.Log(s) }
+	mc := NewMockMqttClient(log)
+	d, err := NewDispatcher(&[]config.Entry{entry}, mc, newTestLogger(t))
+	require.NoError(t, err)
+	d.runMqtt(context.Background(), config.MqttEntryImpl{Entry: entry})
+	for _, p := range payloads {
+		mc.SimulateMessage("sensor/door", []byte(p))
+	}
+	return mc
+}
+
+func TestCallbackStringValue(t *testing.T) {
+	entry := newTextEntry(config.TransformDefinition{JsonPath: "$.state", ValueType: "string"}, "Door %s")
+	entry.TextColorCallback = func(v string) (string, error) {
+		if v == "open" {
+			return "#FF0000", nil
+		}
+		return "#00FF00", nil
+	}
+
+	mc := runTextEntry(t, entry, `{"state": "open"}`)
+	assert.Equal(t, `{"text":"Door open","icon":"door","color":"#FF0000"}`, lastMessage(mc, "display/door"))
+}
+
+func TestCallbackStringValueWithMapping(t *testing.T) {
+	entry := newTextEntry(config.TransformDefinition{
+		JsonPath:  "$.title",
+		ValueType: "string",
+		Mapping:   map[string]string{"": "-"},
+	}, "")
+
+	mc := runTextEntry(t, entry, `{"title": "Bohemian Rhapsody"}`)
+	assert.Equal(t, `{"text":"Bohemian Rhapsody","icon":"door"}`, lastMessage(mc, "display/door"))
+
+	mc = runTextEntry(t, entry, `{"title": ""}`)
+	assert.Equal(t, `{"text":"-","icon":"door"}`, lastMessage(mc, "display/door"))
+}
+
+func TestCallbackBoolValue(t *testing.T) {
+	entry := newTextEntry(config.TransformDefinition{
+		JsonPath:  "$.output",
+		ValueType: "bool",
+		Mapping:   map[string]string{"true": "ON", "false": "OFF"},
+	}, "")
+
+	mc := runTextEntry(t, entry, `{"output": true}`)
+	assert.Equal(t, `{"text":"ON","icon":"door"}`, lastMessage(mc, "display/door"))
+
+	mc.SimulateMessage("sensor/door", []byte(`{"output": false}`))
+	assert.Equal(t, `{"text":"OFF","icon":"door"}`, lastMessage(mc, "display/door"))
+
+	// Not a bool -> nothing published.
+	mc = runTextEntry(t, entry, `{"output": "maybe"}`)
+	assert.Equal(t, 0, mc.GetPublishCount("display/door"))
+}
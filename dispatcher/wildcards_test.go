@@ -0,0 +1,60 @@
+package dispatcher
+
+import (
+	"context"
+	"go-mqtt-dispatcher/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWildcardSum(t *testing.T) {
+	mc := NewMockMqttClient(func(s string) { t.Log(s) })
+	entry := newMqttEntry("plugs", "shellies/+/status/switch:0", "awtrix/custom/plugs")
+	entry.Source.MqttSource.TopicsToSubscribe[0].Transform.JsonPath = "$.apower"
+	entry.Operation = "sum"
+
+	d, err := NewDispatcher(&[]config.Entry{entry}, mc, newTestLogger(t))
+	require.NoError(t, err)
+	d.Run(context.Background())
+	defer d.Stop()
+
+	assert.True(t, mc.IsSubscribed("shellies/+/status/switch:0"))
+
+	// Every matched topic is a source of its own.
+	mc.SimulateMessage("shellies/plug-1/status/switch:0", []byte(`{"apower":10}`))
+	assert.Equal(t, `{"text":"10"}`, lastMessage(mc, "awtrix/custom/plugs"))
+	mc.SimulateMessage("shellies/plug-2/status/switch:0", []byte(`{"apower":5}`))
+	assert.Equal(t, `{"text":"15"}`, lastMessage(mc, "awtrix/custom/plugs"))
+	mc.SimulateMessage("shellies/plug-1/status/switch:0", []byte(`{"apower":20}`))
+	assert.Equal(t, `{"text":"25"}`, lastMessage(mc, "awtrix/custom/plugs"))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	assert.Equal(t, map[string]float64{
+		"shellies/plug-1/status/switch:0": 20,
+		"shellies/plug-2/status/switch:0": 5,
+	}, d.state["plugs"])
+}
+
+func TestWildcardFanOut(t *testing.T) {
+	mc := NewMockMqttClient(func(s string) { t.Log(s) })
+	entry := newMqttEntry("plugs", "shellies/+/status/switch:0", "awtrix/custom/{1}")
+	entry.Source.MqttSource.TopicsToSubscribe[0].Transform.JsonPath = "$.apower"
+	entry.TopicsToPublish = append(entry.TopicsToPublish, config.MqttTopicDefinition{Topic: "awtrix/custom/plugs"})
+	entry.Operation = "sum"
+
+	d, err := NewDispatcher(&[]config.Entry{entry}, mc, newTestLogger(t))
+	require.NoError(t, err)
+	d.Run(context.Background())
+	defer d.Stop()
+
+	mc.SimulateMessage("shellies/plug-1/status/switch:0", []byte(`{"apower":10}`))
+	mc.SimulateMessage("shellies/plug-2/status/switch:0", []byte(`{"apower":5}`))
+
+	// The topics with placeholders get the value of each plug, the others the sum.
+	assert.Equal(t, `{"text":"10"}`, lastMessage(mc, "awtrix/custom/plug-1"))
+	assert.Equal(t, `{"text":"5"}`, lastMessage(mc, "awtrix/custom/plug-2"))
+	assert.Equal(t, `{"text":"15"}`, lastMessage(mc, "awtrix/custom/plugs"))
+}
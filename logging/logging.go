@@ -0,0 +1,162 @@
+// Package logging creates the slog logger of the dispatcher.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+const (
+	FormatText = "text"
+	FormatJson = "json"
+)
+
+// ParseLevel parses debug, info, warn or error; empty is info.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level '%s'", s)
+	}
+	return l, nil
+}
+
+// New returns a logger writing text or json records to w. The records are
+// filtered by level, which may be a *slog.LevelVar to change it at runtime, and
+// the secrets of r are redacted if r is not nil.
+func New(w io.Writer, format string, level slog.Leveler, r *Redactor) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if r != nil {
+		opts.ReplaceAttr = r.replaceAttr
+	}
+	var h slog.Handler
+	if strings.EqualFold(format, FormatJson) {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(&LevelHandler{next: h, level: level})
+}
+
+const redacted = "***"
+
+// minSecretLength is the length below which a secret is not redacted, a short
+// password like "on" would mask the same text in unrelated values and topics.
+const minSecretLength = 4
+
+// Redactor replaces secrets, e.g. passwords and api keys, with ***.
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+func NewRedactor() *Redactor {
+	return &Redactor{}
+}
+
+// SetSecrets replaces the secrets to redact, ones shorter than minSecretLength
+// are ignored.
+func (r *Redactor) SetSecrets(secrets []string) {
+	var s []string
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength {
+			s = append(s, secret)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secrets = s
+}
+
+// Redact returns s with all secrets replaced, a nil Redactor returns s.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// replaceAttr redacts the message and the values of a record. Other values
+// than strings, e.g. errors, urls or lists of topics, are redacted in their
+// printed form, which replaces them if it contained a secret. Groups are
+// redacted attr by attr.
+func (r *Redactor) replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.Redact(a.Value.String()))
+	case slog.KindGroup:
+		attrs := make([]slog.Attr, 0, len(a.Value.Group()))
+		for _, ga := range a.Value.Group() {
+			attrs = append(attrs, r.replaceAttr(append(groups, a.Key), ga))
+		}
+		a.Value = slog.GroupValue(attrs...)
+	case slog.KindAny:
+		s := printed(a.Value.Any())
+		if redacted := r.Redact(s); redacted != s {
+			a.Value = slog.StringValue(redacted)
+		}
+	}
+	return a
+}
+
+// printed returns v as the log handlers print it.
+func printed(v any) string {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprintf("%+v", v)
+}
+
+// LevelHandler filters the records of the next handler by level. The next
+// handler must accept debug records, so WithLevel can lower the level of a
+// single logger, e.g. for entries with debug enabled.
+type LevelHandler struct {
+	next  slog.Handler
+	level slog.Leveler
+}
+
+func (h *LevelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.next.Enabled(ctx, level)
+}
+
+func (h *LevelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *LevelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &LevelHandler{next: h.next.WithAttrs(attrs), level: h.level}
+}
+
+func (h *LevelHandler) WithGroup(name string) slog.Handler {
+	return &LevelHandler{next: h.next.WithGroup(name), level: h.level}
+}
+
+// WithLevel returns the logger with its records filtered by level instead. A
+// logger without LevelHandler is returned unchanged.
+func WithLevel(l *slog.Logger, level slog.Leveler) *slog.Logger {
+	h, ok := l.Handler().(*LevelHandler)
+	if !ok {
+		return l
+	}
+	return slog.New(&LevelHandler{next: h.next, level: level})
+}
+
+// Discard returns a logger that drops all records.
+func Discard() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
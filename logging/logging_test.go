@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelHandler(t *testing.T) {
+	var buf bytes.Buffer
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelInfo)
+	l := New(&buf, FormatJson, level, nil).With("entry", "power")
+
+	l.Debug("hidden")
+	l.Info("shown")
+	WithLevel(l, slog.LevelDebug).Debug("entry debug")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), `"msg":"shown","entry":"power"`)
+	assert.Contains(t, buf.String(), `"msg":"entry debug","entry":"power"`)
+
+	buf.Reset()
+	level.Set(slog.LevelDebug)
+	l.Debug("now shown")
+	assert.Contains(t, buf.String(), "now shown")
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]slog.Level{"": slog.LevelInfo, "debug": slog.LevelDebug, "WARN": slog.LevelWarn, "error": slog.LevelError} {
+		l, err := ParseLevel(s)
+		assert.NoError(t, err)
+		assert.Equal(t, want, l)
+	}
+	_, err := ParseLevel("verbose")
+	assert.ErrorContains(t, err, "invalid log level 'verbose'")
+}
+
+func TestRedactor(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewRedactor()
+	r.SetSecrets([]string{"s3cret", "", "on"})
+	l := New(&buf, FormatText, slog.LevelInfo, r)
+
+	l.Info("connecting with s3cret", "url", "http://x/?token=s3cret", "error", errors.New("auth s3cret failed"), "count", 3)
+	assert.NotContains(t, buf.String(), "s3cret")
+	assert.Contains(t, buf.String(), `msg="connecting with ***" url="http://x/?token=***" error="auth *** failed" count=3`)
+
+	// Short secrets would mask unrelated text
+	assert.Equal(t, "switch on", r.Redact("switch on"))
+
+	r.SetSecrets(nil)
+	assert.Equal(t, "s3cret", r.Redact("s3cret"))
+}
+
+// secretValuer logs a secret through slog.LogValuer.
+type secretValuer struct{}
+
+func (secretValuer) LogValue() slog.Value { return slog.StringValue("key s3cret") }
+
+func TestRedactorAnyValues(t *testing.T) {
+	r := NewRedactor()
+	r.SetSecrets([]string{"s3cret"})
+	u, _ := url.Parse("https://api.example.com/?token=s3cret")
+
+	for _, format := range []string{FormatText, FormatJson} {
+		var buf bytes.Buffer
+		l := New(&buf, format, slog.LevelInfo, r)
+		l.Info("poll failed",
+			"error", fmt.Errorf("poll: %w", &url.Error{Op: "Get", URL: u.String(), Err: errors.New("timeout")}),
+			"url", u,
+			"topics", []string{"power", "s3cret/topic"},
+			"valuer", secretValuer{},
+			slog.Group("source", "url", *u, slog.Group("auth", "key", "s3cret")),
+		)
+		l.WithGroup("entry").Info("polled", "urls", []*url.URL{u})
+		assert.NotContains(t, buf.String(), "s3cret", format)
+		assert.Contains(t, buf.String(), "token=***", format)
+	}
+
+	// Values without secrets keep their form
+	var buf bytes.Buffer
+	New(&buf, FormatJson, slog.LevelInfo, r).Info("subscribed", "topics", []string{"power", "solar"})
+	assert.Contains(t, buf.String(), `"topics":["power","solar"]`)
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"go-mqtt-dispatcher/config"
 	"go-mqtt-dispatcher/dispatcher"
-	"log"
-	"net/url"
+	"go-mqtt-dispatcher/logging"
+	"go-mqtt-dispatcher/server"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -25,14 +33,41 @@ var (
 var (
 	configPathFlag = flag.String("config", "", "config file path, e.g. config.yaml")
 	configCheck    = flag.Bool("config-check", false, "check config file and exit")
+	watchConfig    = flag.Bool("watch-config", true, "reload the config file when it changes or on SIGHUP")
+	healthcheck    = flag.String("healthcheck", "", "check the health endpoint at url and exit, e.g. http://localhost:9100/healthz")
 )
 
+const (
+	configWatchInterval = 5 * time.Second
+)
+
+var (
+	// logLevel is shared by all loggers, a reload applies a changed level.
+	logLevel = new(slog.LevelVar)
+	// redactor removes the secrets of the config from all log records.
+	redactor = logging.NewRedactor()
+	logger   = logging.New(os.Stderr, logging.FormatText, logLevel, redactor)
+)
+
+// fatal logs msg with err and exits.
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
 func main() {
 	fmt.Printf("%s %s (commit: %s, built at: %s)\n", AppName, Version, Commit, BuildTime)
 	fmt.Println("Url: https://github.com/dhcgn/go-mqtt-dispatcher")
 	fmt.Println()
 
 	flag.Parse()
+	if *healthcheck != "" {
+		if err := server.CheckHealth(*healthcheck); err != nil {
+			fatal("Health check failed", err)
+		}
+		return
+	}
+
 	if *configPathFlag == "" {
 		flag.PrintDefaults()
 		return
@@ -41,48 +76,298 @@ func main() {
 	// Load config
 	config, err := config.LoadConfig(*configPathFlag)
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		if *configCheck {
+			if n := printProblems(err); n > 0 {
+				err = fmt.Errorf("%d problems", n)
+			}
+		}
+		fatal("Failed to load config", err)
+	}
+	redactor.SetSecrets(config.Secrets())
+
+	if config.Logging != nil {
+		level, _ := logging.ParseLevel(config.Logging.Level)
+		logLevel.Set(level)
+		logger = logging.New(os.Stderr, config.Logging.Format, logLevel, redactor)
 	}
 
 	if *configCheck {
-		log.Println("Config check successful")
+		// The resolved config, with references substituted and secrets redacted
+		out, err := yaml.Marshal(config.Redacted())
+		if err != nil {
+			fatal("Failed to print config", err)
+		}
+		fmt.Println(redactor.Redact(string(out)))
+		for _, w := range config.Warnings() {
+			fmt.Println(w)
+		}
+		logger.Info("Config check successful")
 		return
 	}
+	logWarnings(config.Warnings())
 
 	// Create MQTT client
-	client, err := connect(AppName+Version+Commit+BuildTime, config.Mqtt.BrokerAsUri, config.Mqtt.Username, config.Mqtt.Password)
+	mqttClient, err := connect(clientId(config.Mqtt), config.Mqtt)
 	if err != nil {
-		log.Fatalf("Failed to connect to MQTT broker: %v", err)
+		fatal("Failed to connect to MQTT broker", err)
+	}
+
+	var opts []dispatcher.Option
+	var brokerClients []brokerClient
+	for _, b := range config.Brokers {
+		c, err := connect(clientId(b), b)
+		if err != nil {
+			fatal("Failed to connect to MQTT broker", fmt.Errorf("%s: %w", b.Name, err))
+		}
+		brokerClients = append(brokerClients, c)
+		opts = append(opts, dispatcher.WithBroker(b.Name, c))
+	}
+	if config.State != nil {
+		store := dispatcher.NewStateStore(config.State.File, config.State.MaxAgeParsed)
+		opts = append(opts, dispatcher.WithStateStore(store, config.State.SaveInterval))
 	}
-	mqttClient := dispatcher.NewPahoMqttClient(client)
 
-	d, err := dispatcher.NewDispatcher(&config.DispatcherEntries, mqttClient, func(s string) { log.Println("Disp: " + s) })
+	var reg *prometheus.Registry
+	if config.HttpServer != nil && config.HttpServer.Metrics {
+		reg = server.NewMetricsRegistry()
+		opts = append(opts, dispatcher.WithMetrics(dispatcher.NewMetrics(reg)))
+	}
+
+	if config.Mqtt.Heartbeat > 0 {
+		opts = append(opts, dispatcher.WithHeartbeat(config.Mqtt.HeartbeatTopic(), config.Mqtt.Heartbeat, Version))
+	}
+
+	d, err := dispatcher.NewDispatcher(&config.DispatcherEntries, mqttClient, logger, opts...)
 	if err != nil {
-		log.Fatalf("Failed to create dispatcher: %v", err)
+		fatal("Failed to create dispatcher", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d.Run(ctx)
+
+	if config.HttpServer != nil {
+		go server.Run(ctx, config.HttpServer.Listen, server.NewHandler(*config.HttpServer, d, reg, logger, redactor), logger)
+	}
+
+	if *watchConfig {
+		files := config.Files()
+		go watchConfigFile(ctx, func() []string { return files }, func() {
+			config = reloadConfig(*configPathFlag, config, d)
+			files = config.Files()
+		})
+	}
+
+	<-ctx.Done()
+	logger.Info("Shutting down")
+	d.Stop()
+	mqttClient.Disconnect()
+	for _, c := range brokerClients {
+		c.Disconnect()
+	}
+}
+
+// watchConfigFile calls reload when the latest modification time of the config
+// files changes or SIGHUP is received, until ctx is done. files is only called
+// by the watching goroutine, which also calls reload.
+func watchConfigFile(ctx context.Context, files func() []string, reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	lastMod := modTime(files())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logger.Info("Received SIGHUP, reloading config")
+			reload()
+			lastMod = modTime(files())
+		case <-ticker.C:
+			if m := modTime(files()); !m.Equal(lastMod) {
+				logger.Info("Config file changed, reloading config")
+				reload()
+				lastMod = modTime(files())
+			}
+		}
+	}
+}
+
+// modTime returns the latest modification time of the paths, a directory is
+// modified when files are added or removed.
+func modTime(paths []string) time.Time {
+	var latest time.Time
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
+
+// reloadConfig loads and validates the config file again and applies the
+// entries to the running dispatcher. An invalid config is rejected and the
+// current one keeps running. It returns the config that is in effect.
+func reloadConfig(path string, current *config.RootConfig, d *dispatcher.Dispatcher) *config.RootConfig {
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		logger.Error("Reload rejected, keeping current config", "error", err)
+		return current
+	}
+	logWarnings(cfg.Warnings())
+	// The secrets of the current config stay in use if a section is ignored
+	redactor.SetSecrets(append(current.Secrets(), cfg.Secrets()...))
+
+	if !sameMqttConfig(current.Mqtt, cfg.Mqtt) {
+		logger.Warn("Changes to the mqtt section need a restart and are ignored")
+		cfg.Mqtt = current.Mqtt
 	}
-	d.Run()
 
-	select {}
+	if !sameBrokers(current.Brokers, cfg.Brokers) {
+		logger.Warn("Changes to the brokers need a restart and are ignored, entries of added brokers are not dispatched")
+		cfg.Brokers = current.Brokers
+	}
+
+	if !sameHttpServerConfig(current.HttpServer, cfg.HttpServer) {
+		logger.Warn("Changes to the http-server section need a restart and are ignored")
+		cfg.HttpServer = current.HttpServer
+	}
+
+	if !sameStateConfig(current.State, cfg.State) {
+		logger.Warn("Changes to the state section need a restart and are ignored")
+		cfg.State = current.State
+	}
+
+	applyLogLevel(current.Logging, cfg.Logging)
+
+	d.Reload(&cfg.DispatcherEntries)
+	return cfg
 }
 
-func connect(clientId string, uri *url.URL, username, password string) (mqtt.Client, error) {
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s", uri.Host))
-	if username != "" {
-		opts.SetUsername(username)
+// printProblems prints every problem of an invalid config on its own line and
+// returns their number.
+func printProblems(err error) int {
+	var verr *config.ValidationError
+	if !errors.As(err, &verr) {
+		return 0
+	}
+	for _, p := range verr.Problems {
+		fmt.Println(p)
+	}
+	fmt.Println()
+	return len(verr.Problems)
+}
 
+func logWarnings(warnings []config.Problem) {
+	for _, w := range warnings {
+		logger.Warn("Config warning", "problem", w.String())
 	}
-	if password != "" {
-		opts.SetPassword(password)
+}
+
+// sameMqttConfig compares the configured mqtt keys, ignoring late bound fields
+// and the qos and retain defaults, which are resolved into the entries.
+func sameMqttConfig(a, b config.MqttConfig) bool {
+	a.BrokerAsUri, a.TLSConfig, a.Qos, a.Retain = nil, nil, nil, nil
+	b.BrokerAsUri, b.TLSConfig, b.Qos, b.Retain = nil, nil, nil, nil
+	return a == b
+}
+
+// sameBrokers compares the configured brokers like sameMqttConfig.
+func sameBrokers(a, b []config.MqttConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameMqttConfig(a[i], b[i]) {
+			return false
+		}
 	}
+	return true
+}
 
-	opts.SetClientID(clientId)
+// sameStateConfig compares the configured state keys, ignoring late bound fields.
+func sameStateConfig(a, b *config.StateConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.File == b.File && a.Interval == b.Interval && a.MaxAge == b.MaxAge
+}
+
+// applyLogLevel sets the level of a reloaded config, a changed format needs a restart.
+func applyLogLevel(current, reloaded *config.LoggingConfig) {
+	var level slog.Level
+	var format, currentFormat string
+	if reloaded != nil {
+		level, _ = logging.ParseLevel(reloaded.Level)
+		format = reloaded.Format
+	}
+	if current != nil {
+		currentFormat = current.Format
+	}
+	if level != logLevel.Level() {
+		logger.Info("Log level changed", "level", level)
+		logLevel.Set(level)
+	}
+	if !strings.EqualFold(format, currentFormat) {
+		logger.Warn("Changes to the logging format need a restart and are ignored")
+	}
+}
+
+// sameHttpServerConfig compares the configured http-server keys.
+func sameHttpServerConfig(a, b *config.HttpServerConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// clientId returns the configured client id, or else one derived from the host
+// name, so that several instances don't disconnect each other.
+func clientId(cfg config.MqttConfig) string {
+	if cfg.ClientId != "" {
+		return cfg.ClientId
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return AppName + "-" + host
+	}
+	return fmt.Sprintf("%s-%d", AppName, os.Getpid())
+}
+
+// brokerClient is the MQTT 3.1.1 or MQTT 5 client of a broker.
+type brokerClient interface {
+	dispatcher.MqttClient
+	Disconnect()
+}
+
+func connect(clientId string, cfg config.MqttConfig) (brokerClient, error) {
+	if cfg.IsMqtt5() {
+		return connectMqtt5(clientId, cfg)
+	}
+	opts := dispatcher.NewClientOptions(clientId, cfg)
+	client := dispatcher.NewPahoMqttClient(opts, logger)
+	if cfg.StatusTopic != "" {
+		client.SetStatusTopic(cfg.StatusTopic)
+	}
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
 
-	client := mqtt.NewClient(opts)
-	token := client.Connect()
-	for !token.WaitTimeout(3 * time.Second) {
+func connectMqtt5(clientId string, cfg config.MqttConfig) (brokerClient, error) {
+	client := dispatcher.NewMqtt5Client(dispatcher.NewMqtt5ClientConfig(clientId, cfg), logger)
+	if cfg.StatusTopic != "" {
+		client.SetStatusTopic(cfg.StatusTopic)
 	}
-	if err := token.Error(); err != nil {
+	if err := client.Connect(); err != nil {
 		return nil, err
 	}
 	return client, nil
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"go-mqtt-dispatcher/dispatcher"
+	"go-mqtt-dispatcher/logging"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+const (
+	// eventBuffer is the number of published messages queued per dashboard
+	// client, further messages are dropped while the client is slow.
+	eventBuffer = 64
+	// eventKeepAlive is the interval of the comments that keep idle event streams open.
+	eventKeepAlive = 30 * time.Second
+)
+
+// dashboardHandler serves the embedded dashboard page.
+func dashboardHandler() http.Handler {
+	web, _ := fs.Sub(webFiles, "web")
+	return http.FileServer(http.FS(web))
+}
+
+// eventsHandler streams every published message as server-sent event.
+func eventsHandler(d Dispatcher, redactor *logging.Redactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		events := make(chan dispatcher.PublishEvent, eventBuffer)
+		remove := d.AddPublishListener(func(e dispatcher.PublishEvent) {
+			select {
+			case events <- e:
+			default:
+			}
+		})
+		defer remove()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(eventKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				w.Write([]byte(": keep-alive\n\n"))
+			case e := <-events:
+				data, err := json.Marshal(redactEvent(redactor, e))
+				if err != nil {
+					continue
+				}
+				w.Write([]byte("event: publish\ndata: "))
+				w.Write(data)
+				w.Write([]byte("\n\n"))
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"go-mqtt-dispatcher/dispatcher"
+	"go-mqtt-dispatcher/logging"
+)
+
+// redactStatus returns s with the secrets redacted from the source ids, which
+// are urls after the secret substitution, and from the payloads and values.
+func redactStatus(r *logging.Redactor, s dispatcher.EntryStatus) dispatcher.EntryStatus {
+	s.ID = r.Redact(s.ID)
+	s.LastPayloads = redactTimedValues(r, s.LastPayloads)
+	s.LastValues = redactTimedValues(r, s.LastValues)
+	s.LastPublished = redactTimedValues(r, s.LastPublished)
+	if s.Accumulation != nil {
+		acc := make(map[string]dispatcher.SourceStatus, len(s.Accumulation))
+		for id, v := range s.Accumulation {
+			acc[r.Redact(id)] = v
+		}
+		s.Accumulation = acc
+	}
+	if s.Fallbacks != nil {
+		fallbacks := make(map[string]dispatcher.FallbackStatus, len(s.Fallbacks))
+		for topic, f := range s.Fallbacks {
+			f.LastValue = r.Redact(f.LastValue)
+			fallbacks[r.Redact(topic)] = f
+		}
+		s.Fallbacks = fallbacks
+	}
+	return s
+}
+
+func redactTimedValues(r *logging.Redactor, values map[string]dispatcher.TimedValue) map[string]dispatcher.TimedValue {
+	if values == nil {
+		return nil
+	}
+	redacted := make(map[string]dispatcher.TimedValue, len(values))
+	for k, v := range values {
+		v.Value = r.Redact(v.Value)
+		redacted[r.Redact(k)] = v
+	}
+	return redacted
+}
+
+// redactEvent returns e with the secrets redacted from the topic and payload.
+func redactEvent(r *logging.Redactor, e dispatcher.PublishEvent) dispatcher.PublishEvent {
+	e.Topic = r.Redact(e.Topic)
+	e.Payload = r.Redact(e.Payload)
+	return e
+}
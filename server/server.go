@@ -0,0 +1,134 @@
+// Package server provides the optional http server of the dispatcher with the
+// health check, the status api, the dashboard and the prometheus metrics.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-mqtt-dispatcher/config"
+	"go-mqtt-dispatcher/dispatcher"
+	"go-mqtt-dispatcher/logging"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	shutdownTimeout    = 5 * time.Second
+	healthcheckTimeout = 3 * time.Second
+)
+
+// Dispatcher is the part of the dispatcher the server reports on.
+type Dispatcher interface {
+	Status() []dispatcher.EntryStatus
+	IsConnected() bool
+	AddPublishListener(func(dispatcher.PublishEvent)) func()
+}
+
+// NewMetricsRegistry returns a registry with the go and process collectors.
+func NewMetricsRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return reg
+}
+
+// NewHandler returns the routes enabled in cfg. /healthz is always served,
+// reg is only used with metrics enabled. The dashboard needs the status api
+// and enables it. The secrets of redactor are removed from the served status
+// and events.
+func NewHandler(cfg config.HttpServerConfig, d Dispatcher, reg *prometheus.Registry, log *slog.Logger, redactor *logging.Redactor) http.Handler {
+	writeJson := func(w http.ResponseWriter, code int, v interface{}) {
+		if err := writeJson(w, code, v); err != nil {
+			log.Warn("Error writing http response", "error", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		health := struct {
+			Status        string `json:"status"`
+			MqttConnected bool   `json:"mqtt-connected"`
+		}{Status: "ok", MqttConnected: d.IsConnected()}
+		code := http.StatusOK
+		if !health.MqttConnected {
+			health.Status = "unhealthy"
+			code = http.StatusServiceUnavailable
+		}
+		writeJson(w, code, health)
+	})
+
+	if cfg.API || cfg.Dashboard {
+		mux.HandleFunc("GET /api/entries", func(w http.ResponseWriter, r *http.Request) {
+			status := d.Status()
+			for i := range status {
+				status[i] = redactStatus(redactor, status[i])
+			}
+			writeJson(w, http.StatusOK, status)
+		})
+		mux.HandleFunc("GET /api/entries/{name}", func(w http.ResponseWriter, r *http.Request) {
+			name := r.PathValue("name")
+			for _, s := range d.Status() {
+				if s.Name == name {
+					writeJson(w, http.StatusOK, redactStatus(redactor, s))
+					return
+				}
+			}
+			writeJson(w, http.StatusNotFound, map[string]string{"error": "entry not found: " + name})
+		})
+	}
+
+	if cfg.Dashboard {
+		mux.HandleFunc("GET /api/events", eventsHandler(d, redactor))
+		mux.Handle("GET /", dashboardHandler())
+	}
+
+	if cfg.Metrics && reg != nil {
+		mux.Handle("GET /metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	}
+	return mux
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// Run serves handler on listen until ctx is done.
+func Run(ctx context.Context, listen string, handler http.Handler, log *slog.Logger) {
+	srv := &http.Server{Addr: listen, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Info("Http server listening", "listen", listen)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("Http server error", "error", err)
+	}
+}
+
+// CheckHealth requests the health endpoint at url and returns an error unless
+// it reports healthy. Used as docker HEALTHCHECK, the image has no curl.
+func CheckHealth(url string) error {
+	client := http.Client{Timeout: healthcheckTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unhealthy: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"go-mqtt-dispatcher/config"
+	"go-mqtt-dispatcher/dispatcher"
+	"go-mqtt-dispatcher/logging"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDispatcher struct {
+	connected bool
+	status    []dispatcher.EntryStatus
+	listeners chan func(dispatcher.PublishEvent)
+}
+
+func (f *fakeDispatcher) Status() []dispatcher.EntryStatus { return f.status }
+func (f *fakeDispatcher) IsConnected() bool                { return f.connected }
+func (f *fakeDispatcher) AddPublishListener(l func(dispatcher.PublishEvent)) func() {
+	if f.listeners != nil {
+		f.listeners <- l
+	}
+	return func() {}
+}
+
+func get(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestHealthz(t *testing.T) {
+	d := &fakeDispatcher{connected: true}
+	h := NewHandler(config.HttpServerConfig{}, d, nil, logging.Discard(), nil)
+
+	rec := get(t, h, "/healthz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok","mqtt-connected":true}`, rec.Body.String())
+
+	d.connected = false
+	rec = get(t, h, "/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"unhealthy","mqtt-connected":false}`, rec.Body.String())
+}
+
+func TestApiEntries(t *testing.T) {
+	d := &fakeDispatcher{connected: true, status: []dispatcher.EntryStatus{
+		{Name: "power", ID: "ABCDEFGH", Type: "Mqtt"},
+		{Name: "solar", Type: "Http", Disabled: true},
+	}}
+
+	rec := get(t, NewHandler(config.HttpServerConfig{}, d, nil, logging.Discard(), nil), "/api/entries")
+	assert.Equal(t, http.StatusNotFound, rec.Code, "api is disabled by default")
+
+	h := NewHandler(config.HttpServerConfig{API: true}, d, nil, logging.Discard(), nil)
+	rec = get(t, h, "/api/entries")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var entries []dispatcher.EntryStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	assert.Equal(t, d.status, entries)
+
+	rec = get(t, h, "/api/entries/solar")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.Contains(rec.Body.String(), `"disabled": true`))
+
+	rec = get(t, h, "/api/entries/unknown")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestApiEntriesRedactsSecrets(t *testing.T) {
+	url := "http://example.com/api?token=t0ken"
+	d := &fakeDispatcher{status: []dispatcher.EntryStatus{{
+		Name:          "power",
+		LastPayloads:  map[string]dispatcher.TimedValue{url: {Value: `{"token":"t0ken","value":5}`}},
+		LastPublished: map[string]dispatcher.TimedValue{"awtrix/power": {Value: `{"text":"5"}`}},
+		Accumulation:  map[string]dispatcher.SourceStatus{url: {Value: 5}},
+	}}}
+	r := logging.NewRedactor()
+	r.SetSecrets([]string{"t0ken"})
+	h := NewHandler(config.HttpServerConfig{API: true}, d, nil, logging.Discard(), r)
+
+	for _, path := range []string{"/api/entries", "/api/entries/power"} {
+		rec := get(t, h, path)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "t0ken")
+		assert.Contains(t, rec.Body.String(), `"http://example.com/api?token=***"`)
+		assert.Contains(t, rec.Body.String(), `"awtrix/power"`)
+	}
+}
+
+func TestMetricsRoute(t *testing.T) {
+	d := &fakeDispatcher{}
+	h := NewHandler(config.HttpServerConfig{Metrics: true}, d, NewMetricsRegistry(), logging.Discard(), nil)
+	rec := get(t, h, "/metrics")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "go_goroutines")
+}
+
+func TestCheckHealth(t *testing.T) {
+	d := &fakeDispatcher{connected: true}
+	srv := httptest.NewServer(NewHandler(config.HttpServerConfig{}, d, nil, logging.Discard(), nil))
+	defer srv.Close()
+
+	assert.NoError(t, CheckHealth(srv.URL+"/healthz"))
+	d.connected = false
+	assert.ErrorContains(t, CheckHealth(srv.URL+"/healthz"), "503")
+}
+
+func TestDashboard(t *testing.T) {
+	d := &fakeDispatcher{listeners: make(chan func(dispatcher.PublishEvent), 1)}
+	h := NewHandler(config.HttpServerConfig{Dashboard: true}, d, nil, logging.Discard(), nil)
+
+	rec := get(t, h, "/")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "EventSource")
+	assert.Equal(t, http.StatusOK, get(t, h, "/api/entries").Code, "the dashboard enables the api")
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/api/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	listener := <-d.listeners
+	listener(dispatcher.PublishEvent{Entry: "power", Topic: "awtrix/power", Payload: `{"text":"392"}`})
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "event: publish\n", line)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Contains(t, line, `"topic":"awtrix/power"`)
+	assert.Contains(t, line, `"payload":"{\"text\":\"392\"}"`)
+}
@@ -0,0 +1,40 @@
+package tibbergraph
+
+// CreateHistoryDraw maps values, oldest first, onto the 32x8 matrix as a line
+// of points ending at the right edge. The newest value is drawn in blue, all
+// others in green. Values beyond 32 are dropped from the start.
+func CreateHistoryDraw(values []float64) GraphData {
+	if len(values) > 32 {
+		values = values[len(values)-32:]
+	}
+
+	var graph GraphData
+	if len(values) == 0 {
+		return graph
+	}
+
+	lowest, highest := values[0], values[0]
+	for _, v := range values {
+		if v < lowest {
+			lowest = v
+		}
+		if v > highest {
+			highest = v
+		}
+	}
+	rangeValue := highest - lowest
+	if rangeValue <= 0 {
+		rangeValue = 1.0 // avoid div-by-zero if all values are identical
+	}
+
+	startX := 32 - len(values)
+	for i, v := range values {
+		y := int(7.0 - ((v - lowest) / rangeValue * 7.0) + 0.5)
+		color := "#00FF00"
+		if i == len(values)-1 {
+			color = "#0000FF"
+		}
+		graph.Draw = append(graph.Draw, DrawCommand{DP: [3]interface{}{startX + i, y, color}})
+	}
+	return graph
+}
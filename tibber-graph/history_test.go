@@ -0,0 +1,24 @@
+package tibbergraph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateHistoryDraw(t *testing.T) {
+	g := CreateHistoryDraw([]float64{0, 50, 100})
+	assert.Equal(t, []DrawCommand{
+		{DP: [3]interface{}{29, 7, "#00FF00"}},
+		{DP: [3]interface{}{30, 4, "#00FF00"}},
+		{DP: [3]interface{}{31, 0, "#0000FF"}},
+	}, g.Draw)
+
+	values := make([]float64, 40)
+	g = CreateHistoryDraw(values)
+	assert.Len(t, g.Draw, 32)
+	assert.Equal(t, 0, g.Draw[0].DP[0])
+	assert.Equal(t, 7, g.Draw[0].DP[1], "identical values are drawn at the bottom")
+
+	assert.Empty(t, CreateHistoryDraw(nil).Draw)
+}